@@ -0,0 +1,311 @@
+package gomongodb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrCheckpointMismatch checkpoint属于其他collection或遍历字段，通常是不同任务使用了相同的Name
+var ErrCheckpointMismatch = errors.New("gomongodb: checkpoint mismatch")
+
+// BatchConfig BatchProcessor的配置
+type BatchConfig struct {
+	// Name 任务名，作为checkpoint的_id，必填。重启时使用相同的Name从checkpoint继续
+	Name string
+	// Field 遍历的字段，默认为_id。需有索引、值唯一且单调递增，在遍历位置之前新增的文档不会被处理
+	Field string
+	// Filter 只处理满足条件的文档，可以是builder.Filter
+	Filter interface{}
+	// BatchSize 每批的文档数，默认1000
+	BatchSize int64
+	// Concurrency 同时执行fn的批数，默认1。大于1时批次的完成顺序不确定，checkpoint只推进到连续完成的最后一批
+	Concurrency int
+	// RateLimit 每秒最多处理的文档数，<=0时不限制
+	RateLimit float64
+	// DryRun 为true时fn的dryRun参数为true，不写checkpoint，fn不应修改数据
+	DryRun bool
+	// CheckpointCollection 保存checkpoint的collection，与遍历的collection在同一个database，默认gomongodb_checkpoints
+	CheckpointCollection string
+}
+
+// Checkpoint BatchProcessor的进度，保存在checkpoint collection中
+type Checkpoint struct {
+	Name string `bson:"_id"`
+	// Namespace 遍历的database.collection
+	Namespace string `bson:"namespace"`
+	Field     string `bson:"field"`
+	// LastKey 已处理的最后一个文档的Field的值，尚未处理任何文档时为空
+	LastKey   bson.RawValue `bson:"last_key,omitempty"`
+	Batches   int64         `bson:"batches"`
+	Processed int64         `bson:"processed"`
+	// Done 已遍历完成，再次Run直接返回，需重新执行时先调用Reset
+	Done      bool      `bson:"done"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+/*
+BatchProcessor 按Field的顺序分批遍历collection并回调fn，用于大collection的数据回填、修复。
+
+每批处理完成后将进度写入checkpoint collection，进程退出后再次Run会从checkpoint继续，而不是从头开始。
+checkpoint在fn成功后才写入，进程在两者之间退出时该批会被再次处理，fn需可重入。
+
+	p := gomongodb.NewBatchProcessor[User](client, "db", "user", gomongodb.BatchConfig{Name: "backfill-nick", Concurrency: 4})
+	cp, err := p.Run(ctx, func(ctx context.Context, batch []User, dryRun bool) error {
+		...
+	})
+*/
+type BatchProcessor[T any] struct {
+	cfg         BatchConfig
+	wrapper     *collectionWrapperGeneric[T]
+	checkpoints CollectionWrapperGeneric[Checkpoint]
+}
+
+// NewBatchProcessor 创建BatchProcessor，opts作用于遍历的collection
+func NewBatchProcessor[T any](client *Client, database, collection string, cfg BatchConfig, opts ...WrapperOption) *BatchProcessor[T] {
+	if cfg.Field == "" {
+		cfg.Field = "_id"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.CheckpointCollection == "" {
+		cfg.CheckpointCollection = "gomongodb_checkpoints"
+	}
+	return &BatchProcessor[T]{
+		cfg:         cfg,
+		wrapper:     NewCollectionWrapper[T](client, database, collection, opts...).(*collectionWrapperGeneric[T]),
+		checkpoints: NewCollectionWrapper[Checkpoint](client, database, cfg.CheckpointCollection),
+	}
+}
+
+// batchJob 读取到的一批文档，seq从0开始连续编号
+type batchJob[T any] struct {
+	seq     int64
+	items   []T
+	lastKey bson.RawValue
+}
+
+// batchProgress 记录完成的批次，按seq连续推进checkpoint
+type batchProgress[T any] struct {
+	mu      sync.Mutex
+	cp      Checkpoint
+	next    int64
+	pending map[int64]batchJob[T]
+}
+
+/*
+Run 从checkpoint继续遍历，每批回调一次fn，返回最终的进度。
+
+fn返回错误时停止读取新的批次，等待执行中的批次结束后返回该错误，checkpoint停在出错批次之前。
+checkpoint已完成时直接返回。ctx取消时返回ctx的错误，已完成的批次的进度会保留，未执行的批次在下次Run时继续。
+*/
+func (p *BatchProcessor[T]) Run(ctx context.Context, fn func(ctx context.Context, batch []T, dryRun bool) error) (*Checkpoint, error) {
+	if p.cfg.Name == "" {
+		return nil, p.wrapper.operationError("BatchProcessor", errors.New("gomongodb: BatchConfig.Name is required"))
+	}
+	cp, err := p.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Done {
+		return cp, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	progress := &batchProgress[T]{cp: *cp, pending: make(map[int64]batchJob[T])}
+	jobs := make(chan batchJob[T], p.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < p.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := ctx.Err(); err != nil {
+					// 未执行的批次不能计入进度
+					fail(err)
+					continue
+				}
+				if err := fn(ctx, job.items, p.cfg.DryRun); err != nil {
+					fail(errors.Wrapf(err, "batch %d", cp.Batches+job.seq+1))
+					continue
+				}
+				if err := p.complete(ctx, progress, job); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+	sent, err := p.read(ctx, typedFilter[T](p.cfg.Filter), cp.LastKey, jobs)
+	if err != nil {
+		fail(err)
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		// 调用方取消时，读取及执行中的批次可能都没有报错
+		fail(err)
+	}
+
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if firstErr == nil && progress.next != sent {
+		// 读取的批次都已提交才算完成
+		firstErr = errors.Errorf("gomongodb: %d of %d batches not committed", sent-progress.next, sent)
+	}
+	if firstErr != nil {
+		result := progress.cp
+		return &result, firstErr
+	}
+	progress.cp.Done = true
+	if err := p.save(ctx, &progress.cp); err != nil {
+		result := progress.cp
+		return &result, err
+	}
+	result := progress.cp
+	return &result, nil
+}
+
+// Reset 删除checkpoint，下次Run从头开始
+func (p *BatchProcessor[T]) Reset(ctx context.Context) error {
+	_, err := p.checkpoints.DeleteID(ctx, p.cfg.Name)
+	return err
+}
+
+// load 读取checkpoint，不存在时返回初始的进度
+func (p *BatchProcessor[T]) load(ctx context.Context) (*Checkpoint, error) {
+	namespace := p.wrapper.database + "." + p.wrapper.collection
+	cp, has, err := p.checkpoints.FindID(ctx, p.cfg.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "load checkpoint")
+	}
+	if !has {
+		return &Checkpoint{Name: p.cfg.Name, Namespace: namespace, Field: p.cfg.Field}, nil
+	}
+	if cp.Namespace != namespace || cp.Field != p.cfg.Field {
+		return nil, errors.Wrapf(ErrCheckpointMismatch, "checkpoint %q is for %s by %s", p.cfg.Name, cp.Namespace, cp.Field)
+	}
+	return &cp, nil
+}
+
+// save 写入checkpoint，DryRun时只更新内存中的进度。
+// fn出错或ctx取消后仍需保存已完成的进度，使用不会被取消的ctx
+func (p *BatchProcessor[T]) save(ctx context.Context, cp *Checkpoint) error {
+	cp.UpdatedAt = time.Now()
+	if p.cfg.DryRun {
+		return nil
+	}
+	set := bson.M{
+		"namespace":  cp.Namespace,
+		"field":      cp.Field,
+		"batches":    cp.Batches,
+		"processed":  cp.Processed,
+		"done":       cp.Done,
+		"updated_at": cp.UpdatedAt,
+	}
+	if !cp.LastKey.IsZero() {
+		set["last_key"] = cp.LastKey
+	}
+	_, err := p.checkpoints.UpdateID(context.WithoutCancel(ctx), cp.Name, bson.M{"$set": set}, true)
+	return errors.Wrap(err, "save checkpoint")
+}
+
+// complete 记录完成的批次，之前的批次都已完成时推进并保存checkpoint
+func (p *BatchProcessor[T]) complete(ctx context.Context, progress *batchProgress[T], job batchJob[T]) error {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	progress.pending[job.seq] = job
+	advanced := false
+	for {
+		done, ok := progress.pending[progress.next]
+		if !ok {
+			break
+		}
+		delete(progress.pending, progress.next)
+		progress.next++
+		progress.cp.LastKey = done.lastKey
+		progress.cp.Batches++
+		progress.cp.Processed += int64(len(done.items))
+		advanced = true
+	}
+	if !advanced {
+		return nil
+	}
+	return p.save(ctx, &progress.cp)
+}
+
+// read 按Field的顺序读取lastKey之后的文档，分批发送到jobs，RateLimit>0时控制发送的速度。返回发送的批次数
+func (p *BatchProcessor[T]) read(ctx context.Context, filter interface{}, lastKey bson.RawValue, jobs chan<- batchJob[T]) (batches int64, err error) {
+	start := time.Now()
+	var sent int64
+	for seq := int64(0); ; seq++ {
+		query := filter
+		if !lastKey.IsZero() {
+			// filter为builder时在每次Find的interceptor链中生成
+			query = andStatement(filter, bson.D{{Key: p.cfg.Field, Value: bson.D{{Key: "$gt", Value: lastKey}}}})
+		} else if query == nil {
+			query = bson.D{}
+		}
+		var raws []bson.Raw
+		if err := p.wrapper.collectionWrapper.Find(ctx, query, &raws, []string{p.cfg.Field}, 0, p.cfg.BatchSize); err != nil {
+			return seq, err
+		}
+		if len(raws) == 0 {
+			return seq, nil
+		}
+
+		job := batchJob[T]{seq: seq, items: make([]T, len(raws))}
+		for i, raw := range raws {
+			if err := bson.Unmarshal(raw, &job.items[i]); err != nil {
+				return seq, errors.Wrap(err, "Unmarshal")
+			}
+		}
+		key, err := raws[len(raws)-1].LookupErr(strings.Split(p.cfg.Field, ".")...)
+		if err != nil {
+			return seq, errors.Wrapf(err, "lookup %s", p.cfg.Field)
+		}
+		job.lastKey, lastKey = key, key
+
+		if p.cfg.RateLimit > 0 {
+			// 此前发送的文档数按RateLimit需要的时间
+			wait := time.Until(start.Add(time.Duration(float64(sent) / p.cfg.RateLimit * float64(time.Second))))
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return seq, ctx.Err()
+				}
+			}
+		}
+		sent += int64(len(raws))
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			return seq, ctx.Err()
+		}
+		if int64(len(raws)) < p.cfg.BatchSize {
+			return seq + 1, nil
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package gomongodb
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type batchDoc struct {
+	ID int64 `bson:"_id"`
+}
+
+// fakeBatchStore 通过Interceptor模拟数据collection及checkpoint collection
+type fakeBatchStore struct {
+	mu    sync.Mutex
+	docs  int64
+	cp    *Checkpoint
+	saves []Checkpoint
+}
+
+func (s *fakeBatchStore) interceptor(t *testing.T) Interceptor {
+	return func(ctx context.Context, op *Operation, invoker Invoker) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch op.Name {
+		case "Find":
+			raw, err := bson.Marshal(op.Filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var after int64
+			if v, err := bson.Raw(raw).LookupErr("_id", "$gt"); err == nil {
+				after = v.AsInt64()
+			} else if v, err := bson.Raw(raw).LookupErr("$and", "1", "_id", "$gt"); err == nil {
+				after = v.AsInt64()
+			}
+			if _, ok := op.Filter.(statementBuilder); ok {
+				t.Errorf("Find filter not built: %v", op.Filter)
+			}
+			var raws []bson.Raw
+			for id := after + 1; id <= s.docs && int64(len(raws)) < op.Limit; id++ {
+				b, _ := bson.Marshal(batchDoc{ID: id})
+				raws = append(raws, b)
+			}
+			*op.Result.(*[]bson.Raw) = raws
+		case "FindID":
+			if s.cp != nil {
+				*op.Result.(*Checkpoint) = *s.cp
+				op.DocumentsReturned = 1
+			}
+		case "UpdateID":
+			set := op.Update.(bson.M)["$set"].(bson.M)
+			cp := Checkpoint{
+				Name:      op.Filter.(bson.M)["_id"].(string),
+				Namespace: set["namespace"].(string),
+				Field:     set["field"].(string),
+				Batches:   set["batches"].(int64),
+				Processed: set["processed"].(int64),
+				Done:      set["done"].(bool),
+			}
+			if v, ok := set["last_key"]; ok {
+				cp.LastKey = v.(bson.RawValue)
+			}
+			s.cp = &cp
+			s.saves = append(s.saves, cp)
+		case "DeleteID":
+			s.cp = nil
+		}
+		return nil
+	}
+}
+
+func newBatchProcessorForTest(t *testing.T, store *fakeBatchStore, cfg BatchConfig) *BatchProcessor[batchDoc] {
+	client := newClientForTest(&recordSink{})
+	client.AddInterceptor(store.interceptor(t))
+	return NewBatchProcessor[batchDoc](client, "db", "col", cfg)
+}
+
+func Test_BatchProcessor(t *testing.T) {
+	store := &fakeBatchStore{docs: 7}
+	p := newBatchProcessorForTest(t, store, BatchConfig{Name: "job", BatchSize: 2, Concurrency: 3})
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var ids []int64
+	cp, err := p.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, doc := range batch {
+			ids = append(ids, doc.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("processed ids = %v", ids)
+	}
+	if !cp.Done || cp.Batches != 4 || cp.Processed != 7 || cp.LastKey.AsInt64() != 7 || cp.Namespace != "db.col" || cp.Field != "_id" {
+		t.Errorf("Run() checkpoint = %+v", cp)
+	}
+	// checkpoint单调推进
+	for i := 1; i < len(store.saves); i++ {
+		if store.saves[i].Processed < store.saves[i-1].Processed {
+			t.Errorf("checkpoint went backwards: %+v", store.saves)
+		}
+	}
+
+	// 已完成的任务不再执行
+	if _, err := p.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		t.Error("fn called after done")
+		return nil
+	}); err != nil {
+		t.Errorf("Run() after done error = %v", err)
+	}
+	if err := p.Reset(ctx); err != nil || store.cp != nil {
+		t.Errorf("Reset() error = %v, checkpoint = %+v", err, store.cp)
+	}
+}
+
+func Test_BatchProcessorResume(t *testing.T) {
+	store := &fakeBatchStore{docs: 7}
+	p := newBatchProcessorForTest(t, store, BatchConfig{Name: "job", BatchSize: 2})
+	ctx := context.Background()
+
+	// 第三批失败，checkpoint停在第二批
+	failed := errors.New("failed")
+	_, err := p.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		if batch[0].ID == 5 {
+			return failed
+		}
+		return nil
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Run() error = %v, want %v", err, failed)
+	}
+	if store.cp == nil || store.cp.Done || store.cp.Processed != 4 || store.cp.LastKey.AsInt64() != 4 {
+		t.Fatalf("checkpoint = %+v", store.cp)
+	}
+
+	// 重启后从checkpoint继续
+	var ids []int64
+	cp, err := p.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		for _, doc := range batch {
+			ids = append(ids, doc.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int64{5, 6, 7}) || !cp.Done || cp.Processed != 7 || cp.Batches != 4 {
+		t.Errorf("Run() ids = %v, checkpoint = %+v", ids, cp)
+	}
+
+	// Field不同的任务不能使用该checkpoint
+	other := newBatchProcessorForTest(t, store, BatchConfig{Name: "job", Field: "created_at"})
+	if _, err := other.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error { return nil }); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("Run() error = %v, want ErrCheckpointMismatch", err)
+	}
+}
+
+func Test_BatchProcessorDryRun(t *testing.T) {
+	store := &fakeBatchStore{docs: 3}
+	p := newBatchProcessorForTest(t, store, BatchConfig{Name: "job", BatchSize: 2, DryRun: true})
+	var batches int
+	cp, err := p.Run(context.Background(), func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		if !dryRun {
+			t.Error("dryRun = false")
+		}
+		batches++
+		return nil
+	})
+	if err != nil || batches != 2 || !cp.Done || cp.Processed != 3 {
+		t.Errorf("Run() = %+v, %v, batches = %d", cp, err, batches)
+	}
+	if len(store.saves) != 0 {
+		t.Errorf("dry run saved checkpoint: %+v", store.saves)
+	}
+
+	var oe *OperationError
+	if _, err := newBatchProcessorForTest(t, store, BatchConfig{}).Run(context.Background(), nil); !errors.As(err, &oe) {
+		t.Errorf("Run() without Name error = %v, want *OperationError", err)
+	}
+}
+
+func Test_BatchProcessorFilterBuilder(t *testing.T) {
+	store := &fakeBatchStore{docs: 5}
+	p := newBatchProcessorForTest(t, store, BatchConfig{Name: "job", BatchSize: 2,
+		Filter: builder.NewFilter[batchDoc]().Gt("_id", 0)})
+	ctx := context.Background()
+
+	cp, err := p.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error { return nil })
+	if err != nil || !cp.Done || cp.Processed != 5 {
+		t.Fatalf("Run() = %+v, %v", cp, err)
+	}
+
+	// 字段错误时在第一次Find的interceptor链中失败，不处理任何批次
+	p = newBatchProcessorForTest(t, &fakeBatchStore{docs: 5}, BatchConfig{Name: "job", BatchSize: 2,
+		Filter: builder.NewFilter[batchDoc]().Gt("idd", 0)})
+	cp, err = p.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		t.Error("fn called with invalid filter")
+		return nil
+	})
+	var oe *OperationError
+	if !errors.Is(err, builder.ErrUnknownField) || !errors.As(err, &oe) || cp == nil || cp.Done || cp.Processed != 0 {
+		t.Errorf("Run() = %+v, %v", cp, err)
+	}
+}
+
+func Test_BatchProcessorCancel(t *testing.T) {
+	store := &fakeBatchStore{docs: 6}
+	p := newBatchProcessorForTest(t, store, BatchConfig{Name: "job", BatchSize: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cp, err := p.Run(ctx, func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || cp.Done || cp.Processed != 2 {
+		t.Fatalf("Run() = %+v, %v, want Canceled after the first batch", cp, err)
+	}
+	if store.cp.Done {
+		t.Fatalf("saved checkpoint %+v should not be done", store.cp)
+	}
+
+	// 下次Run继续处理剩余的文档
+	var ids []int64
+	cp, err = p.Run(context.Background(), func(ctx context.Context, batch []batchDoc, dryRun bool) error {
+		for _, doc := range batch {
+			ids = append(ids, doc.ID)
+		}
+		return nil
+	})
+	if err != nil || !cp.Done || cp.Processed != 6 || !reflect.DeepEqual(ids, []int64{3, 4, 5, 6}) {
+		t.Errorf("Run() = %+v, %v, ids = %v", cp, err, ids)
+	}
+}
+
+func Test_BatchProcessorRateLimit(t *testing.T) {
+	store := &fakeBatchStore{docs: 3}
+	p := newBatchProcessorForTest(t, store, BatchConfig{Name: "job", BatchSize: 1, RateLimit: 20})
+	st := time.Now()
+	if _, err := p.Run(context.Background(), func(ctx context.Context, batch []batchDoc, dryRun bool) error { return nil }); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// 第1条立即发送，第2、3条间隔50ms
+	if d := time.Since(st); d < 100*time.Millisecond {
+		t.Errorf("Run() took %v, want >= 100ms", d)
+	}
+}
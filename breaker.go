@@ -0,0 +1,254 @@
+package gomongodb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen 熔断期间快速失败，可通过errors.Is(err, ErrCircuitOpen)判断
+var ErrCircuitOpen = errors.New("gomongodb: circuit open")
+
+// CircuitOpenError 熔断的详细信息
+type CircuitOpenError struct {
+	Target     string
+	Database   string
+	Collection string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("gomongodb: circuit open, target %s, %s.%s", e.Target, e.Database, e.Collection)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreakerConfig 熔断配置，见WithCircuitBreaker。按client及collection（经AddMetricsLabelConverter转换后）分别熔断。
+type CircuitBreakerConfig struct {
+	// Window 统计错误率的时间窗口，默认10s，最小10ms
+	Window time.Duration
+	// MinRequests 窗口内的请求数达到该值后才按错误率判断，默认20
+	MinRequests int
+	// ErrorRate 窗口内的错误率达到该值时熔断，取值(0, 1]，<=0时不按错误率熔断
+	ErrorRate float64
+	// ConsecutiveTimeouts 连续超时达到该次数时熔断，<=0时不按超时熔断
+	ConsecutiveTimeouts int
+	// OpenDuration 熔断持续的时间，之后进入半开状态，默认5s
+	OpenDuration time.Duration
+	// HalfOpenProbes 半开状态放行的探测请求数，全部成功后恢复，任一失败则重新熔断，默认1
+	HalfOpenProbes int
+	// Classifier 判断错误是否计入熔断，默认为IsRetryableError，其余错误视为服务端正常响应。
+	// 计入熔断的错误中，ErrorType为timeout的错误同时计入连续超时
+	Classifier func(err error) bool
+}
+
+func (cfg CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	} else if cfg.Window < minCircuitWindow {
+		// 窗口分为circuitBuckets个桶，过小时桶的宽度为0
+		cfg.Window = minCircuitWindow
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = 5 * time.Second
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	if cfg.Classifier == nil {
+		cfg.Classifier = IsRetryableError
+	}
+	return cfg
+}
+
+type circuitState int
+
+// 与metrics中circuit_state的取值一致
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+const circuitBuckets = 10
+
+// minCircuitWindow Window的最小值，每个桶至少1ms
+const minCircuitWindow = circuitBuckets * time.Millisecond
+
+type circuitBucket struct {
+	start    time.Time
+	total    int
+	failures int
+}
+
+type circuitBreaker struct {
+	cfg     CircuitBreakerConfig
+	onState func(state circuitState)
+
+	mu                  sync.Mutex
+	state               circuitState
+	openedAt            time.Time
+	buckets             [circuitBuckets]circuitBucket
+	consecutiveTimeouts int
+	probesInFlight      int
+	probeSuccesses      int
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig, onState func(state circuitState)) *circuitBreaker {
+	b := &circuitBreaker{cfg: cfg.withDefaults(), onState: onState}
+	b.onState(circuitClosed)
+	return b
+}
+
+// allow 判断是否放行，probe为true时表示半开状态的探测请求
+func (b *circuitBreaker) allow(now time.Time) (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitClosed:
+		return true, false
+	case circuitOpen:
+		if now.Sub(b.openedAt) < b.cfg.OpenDuration {
+			return false, false
+		}
+		b.setState(circuitHalfOpen)
+		b.probesInFlight, b.probeSuccesses = 0, 0
+	}
+	if b.probesInFlight >= b.cfg.HalfOpenProbes {
+		return false, false
+	}
+	b.probesInFlight++
+	return true, true
+}
+
+// record 记录放行请求的结果，ignore为true时不计入统计，如等待并发令牌失败
+func (b *circuitBreaker) record(now time.Time, probe, ignore bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := err != nil && b.cfg.Classifier(err)
+	// 与metrics的error_type使用同一分类，Classifier只决定是否计入熔断。
+	// 调用方取消及客户端侧的并发限制不代表服务端的状态
+	errType := ErrorType(err)
+	ignore = ignore || errType == ErrorTypeContextCanceled || errType == ErrorTypePoolExhausted
+
+	if probe {
+		if b.state != circuitHalfOpen {
+			return
+		}
+		b.probesInFlight--
+		switch {
+		case ignore:
+		case failed:
+			b.trip(now)
+		default:
+			b.probeSuccesses++
+			if b.probeSuccesses >= b.cfg.HalfOpenProbes {
+				b.reset()
+			}
+		}
+		return
+	}
+	if b.state != circuitClosed || ignore {
+		return
+	}
+
+	bucket := b.bucket(now)
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+	if failed && errType == ErrorTypeTimeout {
+		b.consecutiveTimeouts++
+	} else {
+		b.consecutiveTimeouts = 0
+	}
+
+	if b.cfg.ConsecutiveTimeouts > 0 && b.consecutiveTimeouts >= b.cfg.ConsecutiveTimeouts {
+		b.trip(now)
+		return
+	}
+	if b.cfg.ErrorRate > 0 {
+		total, failures := b.count(now)
+		if total >= b.cfg.MinRequests && float64(failures)/float64(total) >= b.cfg.ErrorRate {
+			b.trip(now)
+		}
+	}
+}
+
+func (b *circuitBreaker) bucket(now time.Time) *circuitBucket {
+	width := b.cfg.Window / circuitBuckets
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%circuitBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{start: start}
+	}
+	return bucket
+}
+
+func (b *circuitBreaker) count(now time.Time) (total, failures int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.cfg.Window {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return
+}
+
+func (b *circuitBreaker) trip(now time.Time) {
+	b.setState(circuitOpen)
+	b.openedAt = now
+}
+
+func (b *circuitBreaker) reset() {
+	b.setState(circuitClosed)
+	b.buckets = [circuitBuckets]circuitBucket{}
+	b.consecutiveTimeouts = 0
+}
+
+func (b *circuitBreaker) setState(state circuitState) {
+	b.state = state
+	b.onState(state)
+}
+
+// circuitBreaker 获取collection对应的熔断器，未开启熔断时返回nil
+func (c *collectionWrapper) circuitBreaker() *circuitBreaker {
+	cfg := c.client.circuitBreaker
+	if cfg == nil {
+		return nil
+	}
+	db, collection := c.client.convertMetricsLabel(c.database), c.client.convertMetricsLabel(c.collection)
+	key := db + "." + collection
+	if b, ok := c.client.breakers.Load(key); ok {
+		return b.(*circuitBreaker)
+	}
+	gauge := c.client.metrics.circuitState.WithLabelValues(c.client.metricTarget, db, collection)
+	b, _ := c.client.breakers.LoadOrStore(key, newCircuitBreaker(*cfg, func(state circuitState) {
+		gauge.Set(float64(state))
+	}))
+	return b.(*circuitBreaker)
+}
+
+// breakerInterceptor 熔断期间直接返回CircuitOpenError，不再等待超时。位于重试之内，每次执行分别计入统计
+func (c *collectionWrapper) breakerInterceptor(ctx context.Context, op *Operation, invoker Invoker) error {
+	b := c.circuitBreaker()
+	if b == nil {
+		return invoker(ctx, op)
+	}
+	ok, probe := b.allow(time.Now())
+	if !ok {
+		return &CircuitOpenError{Target: c.client.metricTarget, Database: op.Database, Collection: op.Collection}
+	}
+	op.poolFailed = false
+	err := invoker(ctx, op)
+	// 等待并发令牌期间超时同样不代表服务端的状态
+	b.record(time.Now(), probe, op.poolFailed, err)
+	return err
+}
@@ -0,0 +1,165 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errTransient = mongo.CommandError{Code: 189, Name: "PrimarySteppedDown"}
+
+func newCircuitBreakerForTest(cfg CircuitBreakerConfig) (*circuitBreaker, *circuitState) {
+	state := new(circuitState)
+	return newCircuitBreaker(cfg, func(s circuitState) { *state = s }), state
+}
+
+func Test_circuitBreaker_errorRate(t *testing.T) {
+	b, state := newCircuitBreakerForTest(CircuitBreakerConfig{ErrorRate: 0.5, MinRequests: 4, OpenDuration: time.Second})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		b.record(now, false, false, errTransient)
+	}
+	if *state != circuitClosed {
+		t.Fatal("should not open before MinRequests")
+	}
+	b.record(now, false, false, nil)
+	if *state != circuitOpen {
+		t.Fatalf("state %v, want open", *state)
+	}
+	if ok, _ := b.allow(now.Add(500 * time.Millisecond)); ok {
+		t.Fatal("should reject while open")
+	}
+
+	// 半开后只放行一个探测请求
+	ok, probe := b.allow(now.Add(time.Second))
+	if !ok || !probe || *state != circuitHalfOpen {
+		t.Fatalf("ok %v probe %v state %v", ok, probe, *state)
+	}
+	if ok, _ := b.allow(now.Add(time.Second)); ok {
+		t.Fatal("should reject beyond HalfOpenProbes")
+	}
+	b.record(now.Add(time.Second), true, false, nil)
+	if *state != circuitClosed {
+		t.Fatalf("state %v, want closed", *state)
+	}
+}
+
+func Test_CircuitBreakerConfig_withDefaults(t *testing.T) {
+	if got := (CircuitBreakerConfig{}).withDefaults().Window; got != 10*time.Second {
+		t.Errorf("Window = %v, want 10s", got)
+	}
+	if got := (CircuitBreakerConfig{Window: time.Nanosecond}).withDefaults().Window; got != minCircuitWindow {
+		t.Errorf("Window = %v, want %v", got, minCircuitWindow)
+	}
+	opts := defaultClientOptions()
+	WithCircuitBreaker(CircuitBreakerConfig{Window: 5})(&opts)
+	if opts.circuitBreaker.Window != minCircuitWindow {
+		t.Errorf("WithCircuitBreaker() Window = %v, want %v", opts.circuitBreaker.Window, minCircuitWindow)
+	}
+
+	// 过小的窗口不会除零
+	b, _ := newCircuitBreakerForTest(CircuitBreakerConfig{Window: 5, ErrorRate: 0.5})
+	b.record(time.Now(), false, false, errTransient)
+}
+
+func Test_circuitBreaker_ignore(t *testing.T) {
+	b, state := newCircuitBreakerForTest(CircuitBreakerConfig{ConsecutiveTimeouts: 1})
+	now := time.Now()
+	b.record(now, false, false, errors.Wrap(context.Canceled, "find"))
+	b.record(now, false, false, ErrPoolExhausted)
+	b.record(now, false, true, context.DeadlineExceeded)
+	if *state != circuitClosed {
+		t.Fatalf("state %v, want closed", *state)
+	}
+	b.record(now, false, false, errors.Wrap(context.DeadlineExceeded, "find"))
+	if *state != circuitOpen {
+		t.Fatalf("state %v, want open", *state)
+	}
+}
+
+func Test_circuitBreaker_consecutiveTimeouts(t *testing.T) {
+	b, state := newCircuitBreakerForTest(CircuitBreakerConfig{ConsecutiveTimeouts: 2})
+	now := time.Now()
+	b.record(now, false, false, context.DeadlineExceeded)
+	b.record(now, false, false, nil)
+	b.record(now, false, false, context.DeadlineExceeded)
+	if *state != circuitClosed {
+		t.Fatal("success should reset consecutive timeouts")
+	}
+	b.record(now, false, false, context.DeadlineExceeded)
+	if *state != circuitOpen {
+		t.Fatalf("state %v, want open", *state)
+	}
+
+	// 探测失败重新熔断
+	ok, probe := b.allow(now.Add(b.cfg.OpenDuration))
+	if !ok || !probe {
+		t.Fatal("should allow probe")
+	}
+	b.record(now.Add(b.cfg.OpenDuration), true, false, context.DeadlineExceeded)
+	if *state != circuitOpen {
+		t.Fatalf("state %v, want open", *state)
+	}
+}
+
+func Test_circuitBreaker_window(t *testing.T) {
+	b, state := newCircuitBreakerForTest(CircuitBreakerConfig{ErrorRate: 0.5, MinRequests: 2, Window: time.Second})
+	now := time.Now()
+	b.record(now, false, false, errTransient)
+	// 超出窗口的错误不再计入
+	b.record(now.Add(2*time.Second), false, false, nil)
+	b.record(now.Add(2*time.Second), false, false, nil)
+	if *state != circuitClosed {
+		t.Fatalf("state %v, want closed", *state)
+	}
+	// 非暂时性的错误视为服务端正常响应
+	b.record(now.Add(2*time.Second), false, false, errors.New("duplicate key"))
+	if *state != circuitClosed {
+		t.Fatalf("state %v, want closed", *state)
+	}
+}
+
+func Test_breakerInterceptor(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	opts := defaultClientOptions()
+	WithRegisterer(prometheus.NewRegistry())(&opts)
+	metrics, err := newClientMetrics(opts.metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.metrics = metrics
+	client.circuitBreaker = &CircuitBreakerConfig{ConsecutiveTimeouts: 1, OpenDuration: time.Hour}
+	c := &collectionWrapper{client: client, database: "db", collection: "col"}
+
+	op := c.newOperation("Find", "find")
+	// 等待并发令牌超时不计入
+	poolTimeout := func(ctx context.Context, op *Operation) error {
+		op.poolFailed = true
+		return errors.Wrap(context.DeadlineExceeded, "wait pool")
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.breakerInterceptor(context.Background(), op, poolTimeout); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("err %v, pool timeout should not open the circuit", err)
+		}
+	}
+	invoker := func(ctx context.Context, op *Operation) error { return context.DeadlineExceeded }
+	if err := c.breakerInterceptor(context.Background(), op, invoker); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	err = c.breakerInterceptor(context.Background(), op, invoker)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err %v, want ErrCircuitOpen", err)
+	}
+	var coe *CircuitOpenError
+	if !errors.As(err, &coe) || coe.Collection != "col" {
+		t.Fatalf("err %#v", err)
+	}
+	if v := testutil.ToFloat64(metrics.circuitState.WithLabelValues("test", "db", "col")); v != float64(circuitOpen) {
+		t.Fatalf("circuit_state %v, want %v", v, circuitOpen)
+	}
+}
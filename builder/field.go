@@ -0,0 +1,142 @@
+package builder
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownField 字段在文档结构体的bson tag中不存在
+var ErrUnknownField = errors.New("builder: unknown field")
+
+// fieldCache 结构体类型到bson字段的映射
+var fieldCache sync.Map
+
+type structFields struct {
+	fields map[string]reflect.Type
+	// inlineMap 有inline的map字段，任意字段名均合法
+	inlineMap bool
+}
+
+// ValidateField 校验字段路径在T的bson tag中是否存在，T不是结构体时不做校验。
+// 路径可使用.访问嵌套字段，数组的下标、$、$[]、$[<identifier>]均视为数组元素。
+func ValidateField[T any](path string) error {
+	_, err := resolveField(typeOf[T](), path)
+	return err
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// resolveField 返回路径对应字段的类型，路径中经过map、interface等无法校验的类型时返回nil
+func resolveField(typ reflect.Type, path string) (reflect.Type, error) {
+	if path == "" {
+		return nil, errors.Wrap(ErrUnknownField, "empty field")
+	}
+	if typ == nil {
+		return nil, nil
+	}
+	root := indirect(typ)
+	if root.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	cur := typ
+	segments := strings.Split(path, ".")
+	for i := 0; i < len(segments); i++ {
+		seg := segments[i]
+		cur = indirect(cur)
+		switch cur.Kind() {
+		case reflect.Struct:
+			sf := getStructFields(cur)
+			next, ok := sf.fields[seg]
+			if !ok {
+				if sf.inlineMap {
+					return nil, nil
+				}
+				return nil, errors.Wrapf(ErrUnknownField, "%q in %s", path, root)
+			}
+			cur = next
+		case reflect.Slice, reflect.Array:
+			if cur.Elem().Kind() == reflect.Uint8 {
+				// []byte、ObjectID等作为整体
+				return nil, errors.Wrapf(ErrUnknownField, "%q in %s", path, root)
+			}
+			cur = cur.Elem()
+			if !isArrayIndex(seg) {
+				// 查询数组中文档的字段，如tags.name
+				i--
+			}
+		case reflect.Map, reflect.Interface:
+			return nil, nil
+		default:
+			return nil, errors.Wrapf(ErrUnknownField, "%q in %s", path, root)
+		}
+	}
+	return cur, nil
+}
+
+func isArrayIndex(seg string) bool {
+	if seg == "$" || strings.HasPrefix(seg, "$[") && strings.HasSuffix(seg, "]") {
+		return true
+	}
+	_, err := strconv.Atoi(seg)
+	return err == nil
+}
+
+func indirect(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// getStructFields 与driver的StructCodec一致：tag为空时使用小写的字段名，-忽略，inline展开
+func getStructFields(typ reflect.Type) *structFields {
+	if v, ok := fieldCache.Load(typ); ok {
+		return v.(*structFields)
+	}
+	sf := &structFields{fields: make(map[string]reflect.Type)}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("bson")
+		if !ok && !strings.Contains(string(field.Tag), ":") {
+			tag = string(field.Tag)
+		}
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(","+opts+",", ",inline,") {
+			switch ft := indirect(field.Type); ft.Kind() {
+			case reflect.Struct:
+				inline := getStructFields(ft)
+				for k, v := range inline.fields {
+					if _, exists := sf.fields[k]; !exists {
+						sf.fields[k] = v
+					}
+				}
+				sf.inlineMap = sf.inlineMap || inline.inlineMap
+			case reflect.Map:
+				sf.inlineMap = true
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		sf.fields[name] = field.Type
+	}
+	fieldCache.Store(typ, sf)
+	return sf
+}
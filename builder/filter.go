@@ -0,0 +1,306 @@
+/*
+Package builder 基于文档结构体的bson tag构造filter、update等语句，字段名在发出请求前校验，防止拼写错误导致查询不到数据。
+
+	filter := builder.NewFilter[User]().
+		Eq("name", "foo").
+		Gte("age", 18).Lt("age", 60).
+		Or(builder.NewFilter[User]().Exists("email", true), builder.NewFilter[User]().In("tags", "vip"))
+	users, err := wrapper.Find(ctx, filter, nil, 0, 10)
+
+Filter、Update可直接作为CollectionWrapper各方法的filter、update参数，字段错误时不发出请求，直接返回error，也可通过Build获取生成的语句。
+Filter作为CollectionWrapperGeneric[T]的filter时，其类型参数需与T相同，否则同样不发出请求。
+*/
+package builder
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Filter 查询条件，字段名按T的bson tag校验，T不是结构体时不校验。
+// 同一字段的多个条件合并为一个文档，如Gte("age", 18).Lt("age", 60)生成{"age": {"$gte": 18, "$lt": 60}}。
+type Filter[T any] struct {
+	typ   reflect.Type
+	conds []fieldCond
+	logic bson.D
+	err   error
+}
+
+type fieldCond struct {
+	field string
+	ops   bson.D
+}
+
+// NewFilter 创建T的查询条件
+func NewFilter[T any]() *Filter[T] {
+	return &Filter[T]{typ: typeOf[T]()}
+}
+
+// Eq 等于，只有该条件时生成{field: value}
+func (f *Filter[T]) Eq(field string, value interface{}) *Filter[T] {
+	return f.Cond(field, "$eq", value)
+}
+
+// Ne 不等于
+func (f *Filter[T]) Ne(field string, value interface{}) *Filter[T] {
+	return f.Cond(field, "$ne", value)
+}
+
+// In 等于values中任一值，field为数组时包含任一值，values只有一个切片时使用该切片
+func (f *Filter[T]) In(field string, values ...interface{}) *Filter[T] {
+	return f.Cond(field, "$in", listValues(values))
+}
+
+// Nin 不等于values中的任何值
+func (f *Filter[T]) Nin(field string, values ...interface{}) *Filter[T] {
+	return f.Cond(field, "$nin", listValues(values))
+}
+
+// All field为数组时包含values中的所有值
+func (f *Filter[T]) All(field string, values ...interface{}) *Filter[T] {
+	return f.Cond(field, "$all", listValues(values))
+}
+
+// Gt 大于
+func (f *Filter[T]) Gt(field string, value interface{}) *Filter[T] {
+	return f.Cond(field, "$gt", value)
+}
+
+// Gte 大于等于
+func (f *Filter[T]) Gte(field string, value interface{}) *Filter[T] {
+	return f.Cond(field, "$gte", value)
+}
+
+// Lt 小于
+func (f *Filter[T]) Lt(field string, value interface{}) *Filter[T] {
+	return f.Cond(field, "$lt", value)
+}
+
+// Lte 小于等于
+func (f *Filter[T]) Lte(field string, value interface{}) *Filter[T] {
+	return f.Cond(field, "$lte", value)
+}
+
+// Exists 字段是否存在
+func (f *Filter[T]) Exists(field string, exists bool) *Filter[T] {
+	return f.Cond(field, "$exists", exists)
+}
+
+// Size field为数组时长度等于size
+func (f *Filter[T]) Size(field string, size int) *Filter[T] {
+	return f.Cond(field, "$size", size)
+}
+
+// Regex 正则匹配，options如i、m，为空时不设置
+func (f *Filter[T]) Regex(field, pattern, options string) *Filter[T] {
+	ops := bson.D{{Key: "$regex", Value: pattern}}
+	if options != "" {
+		ops = append(ops, bson.E{Key: "$options", Value: options})
+	}
+	return f.cond(field, ops...)
+}
+
+// ElemMatch field为文档数组时，至少有一个元素满足build中的所有条件。
+// build中的字段按数组元素的bson tag校验，元素类型无法确定时不校验。
+func (f *Filter[T]) ElemMatch(field string, build func(elem *Filter[any])) *Filter[T] {
+	typ, err := resolveField(f.typ, field)
+	if err != nil {
+		return f.fail(err)
+	}
+	elem := &Filter[any]{}
+	if typ != nil {
+		if t := indirect(typ); t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			elem.typ = t.Elem()
+		}
+	}
+	build(elem)
+	d, err := elem.Build()
+	if err != nil {
+		return f.fail(errors.Wrapf(err, "$elemMatch %s", field))
+	}
+	return f.Cond(field, "$elemMatch", d)
+}
+
+// GeoWithin 位于geometry内，geometry为GeoJSON的Polygon、MultiPolygon，见Polygon
+func (f *Filter[T]) GeoWithin(field string, geometry interface{}) *Filter[T] {
+	return f.Cond(field, "$geoWithin", bson.D{{Key: "$geometry", Value: geometry}})
+}
+
+// GeoWithinCenterSphere 位于以(lng, lat)为圆心的球面圆内，radius单位为弧度，即距离除以地球半径
+func (f *Filter[T]) GeoWithinCenterSphere(field string, lng, lat, radius float64) *Filter[T] {
+	return f.Cond(field, "$geoWithin", bson.D{{Key: "$centerSphere", Value: bson.A{bson.A{lng, lat}, radius}}})
+}
+
+// GeoIntersects 与geometry相交
+func (f *Filter[T]) GeoIntersects(field string, geometry interface{}) *Filter[T] {
+	return f.Cond(field, "$geoIntersects", bson.D{{Key: "$geometry", Value: geometry}})
+}
+
+// Near 按与point的距离由近到远返回，需要2dsphere索引，minDistance、maxDistance单位为米，为0时不限制
+func (f *Filter[T]) Near(field string, point interface{}, minDistance, maxDistance float64) *Filter[T] {
+	return f.Cond(field, "$near", nearQuery(point, minDistance, maxDistance))
+}
+
+// NearSphere 同Near，按球面距离计算
+func (f *Filter[T]) NearSphere(field string, point interface{}, minDistance, maxDistance float64) *Filter[T] {
+	return f.Cond(field, "$nearSphere", nearQuery(point, minDistance, maxDistance))
+}
+
+func nearQuery(point interface{}, minDistance, maxDistance float64) bson.D {
+	d := bson.D{{Key: "$geometry", Value: point}}
+	if minDistance > 0 {
+		d = append(d, bson.E{Key: "$minDistance", Value: minDistance})
+	}
+	if maxDistance > 0 {
+		d = append(d, bson.E{Key: "$maxDistance", Value: maxDistance})
+	}
+	return d
+}
+
+// Point GeoJSON的点
+func Point(lng, lat float64) bson.D {
+	return bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: bson.A{lng, lat}}}
+}
+
+// Polygon GeoJSON的多边形，只有外环，首尾的点不同时自动闭合
+func Polygon(points ...[2]float64) bson.D {
+	ring := make(bson.A, 0, len(points)+1)
+	for _, p := range points {
+		ring = append(ring, bson.A{p[0], p[1]})
+	}
+	if len(points) > 0 && points[0] != points[len(points)-1] {
+		ring = append(ring, bson.A{points[0][0], points[0][1]})
+	}
+	return bson.D{{Key: "type", Value: "Polygon"}, {Key: "coordinates", Value: bson.A{ring}}}
+}
+
+// And 满足所有filters
+func (f *Filter[T]) And(filters ...*Filter[T]) *Filter[T] {
+	return f.logical("$and", filters)
+}
+
+// Or 满足任一filters
+func (f *Filter[T]) Or(filters ...*Filter[T]) *Filter[T] {
+	return f.logical("$or", filters)
+}
+
+// Nor 不满足任何filters
+func (f *Filter[T]) Nor(filters ...*Filter[T]) *Filter[T] {
+	return f.logical("$nor", filters)
+}
+
+func (f *Filter[T]) logical(op string, filters []*Filter[T]) *Filter[T] {
+	if len(filters) == 0 {
+		return f
+	}
+	clauses := make(bson.A, 0, len(filters))
+	for _, sub := range filters {
+		d, err := sub.Build()
+		if err != nil {
+			return f.fail(errors.Wrap(err, op))
+		}
+		clauses = append(clauses, d)
+	}
+	f.logic = append(f.logic, bson.E{Key: op, Value: clauses})
+	return f
+}
+
+// Cond 其他比较运算符，如Cond("age", "$mod", bson.A{2, 0})
+func (f *Filter[T]) Cond(field, operator string, value interface{}) *Filter[T] {
+	return f.cond(field, bson.E{Key: operator, Value: value})
+}
+
+// cond 为field添加一组运算符，任一运算符重复时整组都不添加
+func (f *Filter[T]) cond(field string, ops ...bson.E) *Filter[T] {
+	if f.err != nil {
+		return f
+	}
+	if _, err := resolveField(f.typ, field); err != nil {
+		return f.fail(err)
+	}
+	for i := range f.conds {
+		if f.conds[i].field != field {
+			continue
+		}
+		for _, e := range f.conds[i].ops {
+			for _, op := range ops {
+				if e.Key == op.Key {
+					return f.fail(errors.Errorf("builder: duplicate %s on %q", op.Key, field))
+				}
+			}
+		}
+		f.conds[i].ops = append(f.conds[i].ops, ops...)
+		return f
+	}
+	f.conds = append(f.conds, fieldCond{field: field, ops: append(bson.D{}, ops...)})
+	return f
+}
+
+func (f *Filter[T]) fail(err error) *Filter[T] {
+	if f.err == nil {
+		f.err = err
+	}
+	return f
+}
+
+// Build 生成filter，有字段不存在等错误时返回第一个错误
+func (f *Filter[T]) Build() (bson.D, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	d := make(bson.D, 0, len(f.conds)+len(f.logic))
+	for _, c := range f.conds {
+		if len(c.ops) == 1 && c.ops[0].Key == "$eq" {
+			d = append(d, bson.E{Key: c.field, Value: c.ops[0].Value})
+			continue
+		}
+		d = append(d, bson.E{Key: c.field, Value: c.ops})
+	}
+	// 多次调用And、Or、Nor时，重复的运算符合并到$and中
+	var and bson.A
+	seen := make(map[string]bool)
+	for _, e := range f.logic {
+		switch {
+		case e.Key == "$and":
+			and = append(and, e.Value.(bson.A)...)
+		case seen[e.Key]:
+			and = append(and, bson.D{e})
+		default:
+			seen[e.Key] = true
+			d = append(d, e)
+		}
+	}
+	if len(and) > 0 {
+		d = append(d, bson.E{Key: "$and", Value: and})
+	}
+	return d, nil
+}
+
+// DocumentType 校验字段所依据的文档类型，即T，CollectionWrapperGeneric据此拒绝为其他文档类型构造的Filter
+func (f *Filter[T]) DocumentType() reflect.Type {
+	return typeOf[T]()
+}
+
+// MarshalBSON 使Filter可直接作为filter参数
+func (f *Filter[T]) MarshalBSON() ([]byte, error) {
+	d, err := f.Build()
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(d)
+}
+
+// listValues 只传入一个切片时展开，如In("tags", []string{"a", "b"})
+func listValues(values []interface{}) interface{} {
+	if len(values) == 1 {
+		if v := reflect.ValueOf(values[0]); v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+			return values[0]
+		}
+	}
+	if values == nil {
+		return bson.A{}
+	}
+	return values
+}
@@ -0,0 +1,240 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testAddress struct {
+	City     string    `bson:"city"`
+	Location bson.D    `bson:"location"`
+	Zip      *string   `bson:"zip,omitempty"`
+	Updated  time.Time `bson:"updated"`
+}
+
+type testBase struct {
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type testUser struct {
+	testBase `bson:",inline"`
+	ID       primitive.ObjectID     `bson:"_id,omitempty"`
+	Name     string                 `bson:"name"`
+	Likes    int64                  `bson:"likes"`
+	Nick     string                 // 无tag时为nick
+	Tags     []string               `bson:"tags"`
+	Address  *testAddress           `bson:"address"`
+	History  []testAddress          `bson:"history"`
+	Extra    map[string]interface{} `bson:"extra"`
+	Ignored  string                 `bson:"-"`
+	secret   string
+}
+
+func TestValidateField(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"_id", false},
+		{"name", false},
+		{"nick", false},
+		{"created_at", false},
+		{"address.city", false},
+		{"address.zip", false},
+		{"history.city", false},
+		{"history.0.city", false},
+		{"history.$.city", false},
+		{"history.$[elem].city", false},
+		{"tags.0", false},
+		{"extra.anything.deep", false},
+		{"lieks", true},
+		{"Name", true},
+		{"Ignored", true},
+		{"secret", true},
+		{"address.town", true},
+		{"history.town", true},
+		{"name.first", true},
+		{"_id.0", true},
+		{"address.updated.sec", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		err := ValidateField[testUser](tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateField(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrUnknownField) {
+			t.Errorf("ValidateField(%q) error = %v, want ErrUnknownField", tt.path, err)
+		}
+	}
+	if err := ValidateField[bson.M]("anything"); err != nil {
+		t.Errorf("ValidateField[bson.M]() error = %v", err)
+	}
+	if err := ValidateField[*testUser]("name"); err != nil {
+		t.Errorf("ValidateField[*testUser]() error = %v", err)
+	}
+}
+
+func TestFilter_Build(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter interface{ Build() (bson.D, error) }
+		want   bson.D
+	}{
+		{
+			name:   "empty",
+			filter: NewFilter[testUser](),
+			want:   bson.D{},
+		},
+		{
+			name:   "eq",
+			filter: NewFilter[testUser]().Eq("name", "foo").Ne("nick", "bar"),
+			want:   bson.D{{Key: "name", Value: "foo"}, {Key: "nick", Value: bson.D{{Key: "$ne", Value: "bar"}}}},
+		},
+		{
+			name:   "range",
+			filter: NewFilter[testUser]().Gte("likes", 1).Lt("likes", 10),
+			want:   bson.D{{Key: "likes", Value: bson.D{{Key: "$gte", Value: 1}, {Key: "$lt", Value: 10}}}},
+		},
+		{
+			name:   "in",
+			filter: NewFilter[testUser]().In("tags", "a", "b").Nin("name", []string{"c"}),
+			want: bson.D{
+				{Key: "tags", Value: bson.D{{Key: "$in", Value: []interface{}{"a", "b"}}}},
+				{Key: "name", Value: bson.D{{Key: "$nin", Value: []string{"c"}}}},
+			},
+		},
+		{
+			name:   "exists and regex",
+			filter: NewFilter[testUser]().Exists("address", true).Regex("name", "^foo", "i"),
+			want: bson.D{
+				{Key: "address", Value: bson.D{{Key: "$exists", Value: true}}},
+				{Key: "name", Value: bson.D{{Key: "$regex", Value: "^foo"}, {Key: "$options", Value: "i"}}},
+			},
+		},
+		{
+			name: "elemMatch",
+			filter: NewFilter[testUser]().ElemMatch("history", func(elem *Filter[any]) {
+				elem.Eq("city", "sh").Exists("zip", true)
+			}),
+			want: bson.D{{Key: "history", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+				{Key: "city", Value: "sh"},
+				{Key: "zip", Value: bson.D{{Key: "$exists", Value: true}}},
+			}}}}},
+		},
+		{
+			name: "logical",
+			filter: NewFilter[testUser]().Eq("name", "foo").
+				Or(NewFilter[testUser]().Gt("likes", 1), NewFilter[testUser]().Exists("tags", true)).
+				Or(NewFilter[testUser]().Eq("nick", "a"), NewFilter[testUser]().Eq("nick", "b")),
+			want: bson.D{
+				{Key: "name", Value: "foo"},
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "likes", Value: bson.D{{Key: "$gt", Value: 1}}}},
+					bson.D{{Key: "tags", Value: bson.D{{Key: "$exists", Value: true}}}},
+				}},
+				{Key: "$and", Value: bson.A{
+					bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "nick", Value: "a"}}, bson.D{{Key: "nick", Value: "b"}}}}},
+				}},
+			},
+		},
+		{
+			name:   "near",
+			filter: NewFilter[testUser]().Near("address.location", Point(121.5, 31.2), 0, 1000),
+			want: bson.D{{Key: "address.location", Value: bson.D{{Key: "$near", Value: bson.D{
+				{Key: "$geometry", Value: bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: bson.A{121.5, 31.2}}}},
+				{Key: "$maxDistance", Value: float64(1000)},
+			}}}}},
+		},
+		{
+			name:   "geoWithin",
+			filter: NewFilter[testUser]().GeoWithin("address.location", Polygon([2]float64{0, 0}, [2]float64{0, 1}, [2]float64{1, 1})),
+			want: bson.D{{Key: "address.location", Value: bson.D{{Key: "$geoWithin", Value: bson.D{
+				{Key: "$geometry", Value: bson.D{{Key: "type", Value: "Polygon"}, {Key: "coordinates", Value: bson.A{
+					bson.A{bson.A{0.0, 0.0}, bson.A{0.0, 1.0}, bson.A{1.0, 1.0}, bson.A{0.0, 0.0}},
+				}}}},
+			}}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Build() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilter_BuildError(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  *Filter[testUser]
+		unknown bool
+	}{
+		{"unknown field", NewFilter[testUser]().Eq("name", "foo").Gt("lieks", 1), true},
+		{"unknown in or", NewFilter[testUser]().Or(NewFilter[testUser]().Eq("nmae", "foo")), true},
+		{"unknown in elemMatch", NewFilter[testUser]().ElemMatch("history", func(elem *Filter[any]) { elem.Eq("town", "sh") }), true},
+		{"duplicate operator", NewFilter[testUser]().Gt("likes", 1).Gt("likes", 2), false},
+		{"duplicate regex", NewFilter[testUser]().Regex("name", "^a", "").Regex("name", "^b", "i"), false},
+		{"duplicate regex options", NewFilter[testUser]().Cond("name", "$options", "m").Regex("name", "^a", "i"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.filter.Build()
+			if err == nil {
+				t.Fatal("Build() want error")
+			}
+			if errors.Is(err, ErrUnknownField) != tt.unknown {
+				t.Errorf("Build() error = %v", err)
+			}
+			if _, err := bson.Marshal(tt.filter); err == nil {
+				t.Errorf("bson.Marshal() want error")
+			}
+		})
+	}
+}
+
+func TestFilter_RegexAtomic(t *testing.T) {
+	// $regex、$options一起添加，任一重复时都不添加
+	f := NewFilter[testUser]().Cond("name", "$options", "m").Regex("name", "^a", "i")
+	if len(f.conds) != 1 || len(f.conds[0].ops) != 1 {
+		t.Errorf("conds = %v, want only $options", f.conds)
+	}
+	got, err := NewFilter[testUser]().Regex("name", "^a", "").Build()
+	if want := (bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^a"}}}}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Build() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestFilter_MarshalBSON(t *testing.T) {
+	b, err := bson.Marshal(NewFilter[testUser]().Eq("name", "foo").Gt("likes", 1))
+	if err != nil {
+		t.Fatalf("bson.Marshal() error = %v", err)
+	}
+	var got bson.D
+	if err := bson.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := bson.D{{Key: "name", Value: "foo"}, {Key: "likes", Value: bson.D{{Key: "$gt", Value: int32(1)}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bson.Marshal() = %v, want %v", got, want)
+	}
+}
+
+func TestFilter_DocumentType(t *testing.T) {
+	if got := NewFilter[testUser]().DocumentType(); got != reflect.TypeOf(testUser{}) {
+		t.Errorf("DocumentType() = %v, want testUser", got)
+	}
+	if got := NewFilter[bson.M]().DocumentType(); got != reflect.TypeOf(bson.M{}) {
+		t.Errorf("DocumentType() = %v, want bson.M", got)
+	}
+}
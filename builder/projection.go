@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"reflect"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Projection 按P的bson tag生成只返回P中字段的projection，如{"likes": 1, "name": 1, "_id": 0}。
+// P中没有_id时排除_id；P不是结构体或有inline的map时返回nil，即返回全部字段。
+func Projection[P any]() bson.D {
+	fields := projectionFields(typeOf[P]())
+	if fields == nil {
+		return nil
+	}
+	d := make(bson.D, 0, len(fields)+1)
+	hasID := false
+	for _, name := range fields {
+		hasID = hasID || name == "_id"
+		d = append(d, bson.E{Key: name, Value: 1})
+	}
+	if !hasID {
+		d = append(d, bson.E{Key: "_id", Value: 0})
+	}
+	return d
+}
+
+// ValidateProjection 校验P的字段在T中均存在，防止P中的拼写错误导致字段始终为空
+func ValidateProjection[T, P any]() error {
+	typ := typeOf[T]()
+	for _, name := range projectionFields(typeOf[P]()) {
+		if _, err := resolveField(typ, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func projectionFields(typ reflect.Type) []string {
+	typ = indirect(typ)
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	sf := getStructFields(typ)
+	if sf.inlineMap {
+		return nil
+	}
+	fields := make([]string, 0, len(sf.fields))
+	for name := range sf.fields {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
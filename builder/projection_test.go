@@ -0,0 +1,66 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type testUserBrief struct {
+	Name  string `bson:"name"`
+	Likes int64  `bson:"likes"`
+}
+
+type testUserWithID struct {
+	testBase `bson:",inline"`
+	ID       string       `bson:"_id"`
+	Address  *testAddress `bson:"address"`
+}
+
+func TestProjection(t *testing.T) {
+	tests := []struct {
+		name string
+		got  bson.D
+		want bson.D
+	}{
+		{
+			name: "without _id",
+			got:  Projection[testUserBrief](),
+			want: bson.D{{Key: "likes", Value: 1}, {Key: "name", Value: 1}, {Key: "_id", Value: 0}},
+		},
+		{
+			name: "with _id and inline",
+			got:  Projection[*testUserWithID](),
+			want: bson.D{{Key: "_id", Value: 1}, {Key: "address", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+		{
+			name: "not struct",
+			got:  Projection[bson.M](),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("Projection() = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateProjection(t *testing.T) {
+	if err := ValidateProjection[testUser, testUserBrief](); err != nil {
+		t.Errorf("ValidateProjection() error = %v", err)
+	}
+	if err := ValidateProjection[testUser, testUserWithID](); err != nil {
+		t.Errorf("ValidateProjection() error = %v", err)
+	}
+	type typo struct {
+		Lieks int64 `bson:"lieks"`
+	}
+	if err := ValidateProjection[testUser, typo](); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("ValidateProjection() error = %v, want ErrUnknownField", err)
+	}
+}
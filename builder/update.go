@@ -0,0 +1,352 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SafeUpdate Update生成的update语句，字段及值的类型均已校验，wrapper不再进行updateSafeCheck。
+// 只能通过Update.Build生成，避免绕过updateSafeCheck
+type SafeUpdate struct {
+	d bson.D
+}
+
+// D 返回update语句的副本
+func (u SafeUpdate) D() bson.D {
+	return append(bson.D(nil), u.d...)
+}
+
+// MarshalBSON 按bson.D序列化
+func (u SafeUpdate) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(u.d)
+}
+
+// Update update语句，字段名及值的类型按T的bson tag校验，T不是结构体时只校验值的类型。
+// 为防止意外覆盖整个子文档，Set的值不能是结构体或map，需设置子文档的具体字段，如Set("address.city", "sh")，
+// 确需整体覆盖时使用SetDocument。
+//
+//	update := builder.NewUpdate[User]().Set("name", "foo").Inc("likes", 1).CurrentDate("updated_at")
+//	_, err := wrapper.UpdateID(ctx, id, update)
+type Update[T any] struct {
+	typ   reflect.Type
+	ops   []updateOp
+	paths []string
+	err   error
+}
+
+type updateOp struct {
+	operator string
+	fields   bson.D
+}
+
+// NewUpdate 创建T的update语句
+func NewUpdate[T any]() *Update[T] {
+	return &Update[T]{typ: typeOf[T]()}
+}
+
+// Set 设置字段的值，值不能是结构体或map
+func (u *Update[T]) Set(field string, value interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	if isDocument(reflect.TypeOf(value)) {
+		return u.fail(errors.Errorf("builder: $set %q with a whole document, set its fields or use SetDocument", field))
+	}
+	return u.checkValue(field, typ, value).add("$set", field, value)
+}
+
+// SetDocument 整体覆盖子文档，子文档中未设置的字段会被删除
+func (u *Update[T]) SetDocument(field string, value interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	return u.checkValue(field, typ, value).add("$set", field, value)
+}
+
+// SetOnInsert upsert插入新文档时设置字段的值，可以是整个子文档
+func (u *Update[T]) SetOnInsert(field string, value interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	return u.checkValue(field, typ, value).add("$setOnInsert", field, value)
+}
+
+// Unset 删除字段
+func (u *Update[T]) Unset(field string) *Update[T] {
+	if _, ok := u.resolve(field); !ok {
+		return u
+	}
+	return u.add("$unset", field, "")
+}
+
+// Inc 字段增加value，value可以为负数
+func (u *Update[T]) Inc(field string, value interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	if !isNumber(reflect.TypeOf(value)) || typ != nil && !isNumber(typ) && indirect(typ).Kind() != reflect.Interface {
+		return u.fail(errors.Errorf("builder: $inc %q requires numbers, got %T", field, value))
+	}
+	return u.add("$inc", field, value)
+}
+
+// Min value小于字段的值时更新
+func (u *Update[T]) Min(field string, value interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	return u.checkValue(field, typ, value).add("$min", field, value)
+}
+
+// Max value大于字段的值时更新
+func (u *Update[T]) Max(field string, value interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	return u.checkValue(field, typ, value).add("$max", field, value)
+}
+
+// CurrentDate 设置为服务端的当前时间，字段类型为primitive.Timestamp时设置为timestamp，否则为date
+func (u *Update[T]) CurrentDate(field string) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	var value interface{} = true
+	if typ != nil {
+		switch t := indirect(typ); t {
+		case reflect.TypeOf(primitive.Timestamp{}):
+			value = bson.D{{Key: "$type", Value: "timestamp"}}
+		case reflect.TypeOf(time.Time{}), reflect.TypeOf(primitive.DateTime(0)):
+		default:
+			if t.Kind() != reflect.Interface {
+				return u.fail(errors.Errorf("builder: $currentDate %q requires a date field, got %s", field, t))
+			}
+		}
+	}
+	return u.add("$currentDate", field, value)
+}
+
+// Push 向数组添加元素，多个values时使用$each
+func (u *Update[T]) Push(field string, values ...interface{}) *Update[T] {
+	return u.arrayOp("$push", field, values)
+}
+
+// AddToSet 数组中不存在时添加元素，多个values时使用$each
+func (u *Update[T]) AddToSet(field string, values ...interface{}) *Update[T] {
+	return u.arrayOp("$addToSet", field, values)
+}
+
+func (u *Update[T]) arrayOp(operator, field string, values []interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	if len(values) == 0 {
+		return u.fail(errors.Errorf("builder: %s %q without values", operator, field))
+	}
+	elem, ok := elemType(typ)
+	if !ok {
+		return u.fail(errors.Errorf("builder: %s %q requires an array field, got %s", operator, field, typ))
+	}
+	for _, v := range values {
+		u.checkValue(field, elem, v)
+	}
+	if len(values) == 1 {
+		return u.add(operator, field, values[0])
+	}
+	return u.add(operator, field, bson.D{{Key: "$each", Value: values}})
+}
+
+// Pull 删除数组中等于value的元素
+func (u *Update[T]) Pull(field string, value interface{}) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	elem, ok := elemType(typ)
+	if !ok {
+		return u.fail(errors.Errorf("builder: $pull %q requires an array field, got %s", field, typ))
+	}
+	return u.checkValue(field, elem, value).add("$pull", field, value)
+}
+
+// PullMatch 删除数组中满足build中所有条件的元素，字段按数组元素的bson tag校验
+func (u *Update[T]) PullMatch(field string, build func(elem *Filter[any])) *Update[T] {
+	typ, ok := u.resolve(field)
+	if !ok {
+		return u
+	}
+	elem, ok := elemType(typ)
+	if !ok {
+		return u.fail(errors.Errorf("builder: $pull %q requires an array field, got %s", field, typ))
+	}
+	filter := &Filter[any]{typ: elem}
+	build(filter)
+	d, err := filter.Build()
+	if err != nil {
+		return u.fail(errors.Wrapf(err, "$pull %s", field))
+	}
+	return u.add("$pull", field, d)
+}
+
+// Build 生成update语句，有字段不存在、类型不匹配、路径冲突等错误时返回第一个错误
+func (u *Update[T]) Build() (SafeUpdate, error) {
+	if u.err != nil {
+		return SafeUpdate{}, u.err
+	}
+	if len(u.ops) == 0 {
+		return SafeUpdate{}, errors.New("builder: empty update")
+	}
+	d := make(bson.D, 0, len(u.ops))
+	for _, op := range u.ops {
+		d = append(d, bson.E{Key: op.operator, Value: op.fields})
+	}
+	return SafeUpdate{d: d}, nil
+}
+
+// MarshalBSON 使Update可直接作为update参数
+func (u *Update[T]) MarshalBSON() ([]byte, error) {
+	d, err := u.Build()
+	if err != nil {
+		return nil, err
+	}
+	return d.MarshalBSON()
+}
+
+// resolve 校验字段，返回字段的类型，无法确定时为nil
+func (u *Update[T]) resolve(field string) (reflect.Type, bool) {
+	if u.err != nil {
+		return nil, false
+	}
+	typ, err := resolveField(u.typ, field)
+	if err != nil {
+		u.fail(err)
+		return nil, false
+	}
+	return typ, true
+}
+
+// add 同一路径或父子路径出现在多个运算符中时，服务端会返回冲突的错误
+func (u *Update[T]) add(operator, field string, value interface{}) *Update[T] {
+	if u.err != nil {
+		return u
+	}
+	for _, p := range u.paths {
+		if p == field || strings.HasPrefix(field, p+".") || strings.HasPrefix(p, field+".") {
+			return u.fail(errors.Errorf("builder: %s %q conflicts with %q", operator, field, p))
+		}
+	}
+	u.paths = append(u.paths, field)
+	for i := range u.ops {
+		if u.ops[i].operator == operator {
+			u.ops[i].fields = append(u.ops[i].fields, bson.E{Key: field, Value: value})
+			return u
+		}
+	}
+	u.ops = append(u.ops, updateOp{operator: operator, fields: bson.D{{Key: field, Value: value}}})
+	return u
+}
+
+func (u *Update[T]) checkValue(field string, typ reflect.Type, value interface{}) *Update[T] {
+	if u.err == nil && !assignable(typ, value) {
+		return u.fail(errors.Errorf("builder: %q is %s, got %T", field, typ, value))
+	}
+	return u
+}
+
+func (u *Update[T]) fail(err error) *Update[T] {
+	if u.err == nil {
+		u.err = err
+	}
+	return u
+}
+
+// assignable value能否保存到typ类型的字段，typ为nil时不校验。数字之间、字符串之间视为兼容。
+func assignable(typ reflect.Type, value interface{}) bool {
+	if typ == nil {
+		return true
+	}
+	if value == nil {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return true
+		}
+		return false
+	}
+	return compatible(indirect(typ), indirect(reflect.TypeOf(value)))
+}
+
+func compatible(ft, vt reflect.Type) bool {
+	switch {
+	case ft.Kind() == reflect.Interface || vt.Kind() == reflect.Interface:
+		return true
+	case vt.AssignableTo(ft):
+		return true
+	case isNumber(ft) && isNumber(vt):
+		return true
+	case ft.Kind() == reflect.String && vt.Kind() == reflect.String, ft.Kind() == reflect.Bool && vt.Kind() == reflect.Bool:
+		return true
+	case (ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array) && (vt.Kind() == reflect.Slice || vt.Kind() == reflect.Array):
+		return compatible(indirect(ft.Elem()), indirect(vt.Elem()))
+	}
+	return false
+}
+
+func isNumber(typ reflect.Type) bool {
+	if typ == nil {
+		return false
+	}
+	switch indirect(typ).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// elemType 数组元素的类型，typ为nil时返回nil, true
+func elemType(typ reflect.Type) (reflect.Type, bool) {
+	if typ == nil {
+		return nil, true
+	}
+	switch t := indirect(typ); t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil, false
+		}
+		return t.Elem(), true
+	case reflect.Interface:
+		return nil, true
+	}
+	return nil, false
+}
+
+// isDocument 值是否会序列化为子文档，time.Time及primitive包中的类型除外
+func isDocument(typ reflect.Type) bool {
+	if typ == nil {
+		return false
+	}
+	t := indirect(typ)
+	switch t.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Slice:
+		return t.Elem() == reflect.TypeOf(bson.E{})
+	case reflect.Struct:
+		return t != reflect.TypeOf(time.Time{}) && t.PkgPath() != reflect.TypeOf(primitive.ObjectID{}).PkgPath()
+	}
+	return false
+}
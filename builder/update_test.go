@@ -0,0 +1,131 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testStats struct {
+	Version primitive.Timestamp `bson:"version"`
+}
+
+func TestUpdate_Build(t *testing.T) {
+	tests := []struct {
+		name   string
+		update *Update[testUser]
+		want   bson.D
+	}{
+		{
+			name:   "set and inc",
+			update: NewUpdate[testUser]().Set("name", "foo").Set("address.city", "sh").Inc("likes", 1),
+			want: bson.D{
+				{Key: "$set", Value: bson.D{{Key: "name", Value: "foo"}, {Key: "address.city", Value: "sh"}}},
+				{Key: "$inc", Value: bson.D{{Key: "likes", Value: 1}}},
+			},
+		},
+		{
+			name:   "unset and currentDate",
+			update: NewUpdate[testUser]().Unset("nick").CurrentDate("created_at"),
+			want: bson.D{
+				{Key: "$unset", Value: bson.D{{Key: "nick", Value: ""}}},
+				{Key: "$currentDate", Value: bson.D{{Key: "created_at", Value: true}}},
+			},
+		},
+		{
+			name:   "array",
+			update: NewUpdate[testUser]().Push("tags", "a").AddToSet("history", testAddress{City: "sh"}, &testAddress{City: "bj"}),
+			want: bson.D{
+				{Key: "$push", Value: bson.D{{Key: "tags", Value: "a"}}},
+				{Key: "$addToSet", Value: bson.D{{Key: "history", Value: bson.D{{Key: "$each", Value: []interface{}{
+					testAddress{City: "sh"}, &testAddress{City: "bj"},
+				}}}}}},
+			},
+		},
+		{
+			name: "pull",
+			update: NewUpdate[testUser]().Pull("tags", "a").PullMatch("history", func(elem *Filter[any]) {
+				elem.Eq("city", "sh")
+			}),
+			want: bson.D{
+				{Key: "$pull", Value: bson.D{
+					{Key: "tags", Value: "a"},
+					{Key: "history", Value: bson.D{{Key: "city", Value: "sh"}}},
+				}},
+			},
+		},
+		{
+			name:   "min max setOnInsert",
+			update: NewUpdate[testUser]().Min("likes", int32(0)).Max("created_at", time.Unix(0, 0)).SetOnInsert("address", testAddress{}),
+			want: bson.D{
+				{Key: "$min", Value: bson.D{{Key: "likes", Value: int32(0)}}},
+				{Key: "$max", Value: bson.D{{Key: "created_at", Value: time.Unix(0, 0)}}},
+				{Key: "$setOnInsert", Value: bson.D{{Key: "address", Value: testAddress{}}}},
+			},
+		},
+		{
+			name:   "set document",
+			update: NewUpdate[testUser]().SetDocument("address", &testAddress{City: "sh"}).Set("extra.any", bson.A{1}),
+			want: bson.D{
+				{Key: "$set", Value: bson.D{{Key: "address", Value: &testAddress{City: "sh"}}, {Key: "extra.any", Value: bson.A{1}}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.update.Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.D(), tt.want) {
+				t.Errorf("Build() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	got, err := NewUpdate[testStats]().CurrentDate("version").Build()
+	if err != nil || !reflect.DeepEqual(got.D()[0].Value, bson.D{{Key: "version", Value: bson.D{{Key: "$type", Value: "timestamp"}}}}) {
+		t.Errorf("Build() = %v, %v", got, err)
+	}
+}
+
+func TestUpdate_BuildError(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  *Update[testUser]
+		unknown bool
+	}{
+		{"empty", NewUpdate[testUser](), false},
+		{"unknown field", NewUpdate[testUser]().Set("lieks", 1), true},
+		{"unknown elem field", NewUpdate[testUser]().PullMatch("history", func(elem *Filter[any]) { elem.Eq("town", "sh") }), true},
+		{"whole document", NewUpdate[testUser]().Set("address", testAddress{}), false},
+		{"whole map", NewUpdate[testUser]().Set("extra", bson.M{}), false},
+		{"type mismatch", NewUpdate[testUser]().Set("likes", "1"), false},
+		{"inc string", NewUpdate[testUser]().Inc("name", 1), false},
+		{"inc non number", NewUpdate[testUser]().Inc("likes", "1"), false},
+		{"push non array", NewUpdate[testUser]().Push("name", "a"), false},
+		{"push elem mismatch", NewUpdate[testUser]().Push("tags", 1), false},
+		{"currentDate non date", NewUpdate[testUser]().CurrentDate("name"), false},
+		{"nil to value", NewUpdate[testUser]().Set("likes", nil), false},
+		{"conflict", NewUpdate[testUser]().Set("name", "a").Unset("name"), false},
+		{"parent conflict", NewUpdate[testUser]().Set("address.city", "a").SetDocument("address", nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.update.Build()
+			if err == nil {
+				t.Fatal("Build() want error")
+			}
+			if errors.Is(err, ErrUnknownField) != tt.unknown {
+				t.Errorf("Build() error = %v", err)
+			}
+			if _, err := bson.Marshal(tt.update); err == nil {
+				t.Errorf("bson.Marshal() want error")
+			}
+		})
+	}
+}
@@ -7,127 +7,262 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
+	// Name 作为metrics的target label及trace的client属性，为空时见WithHostsAsName
+	Name               string `json:"name" ini:"name" yaml:"name"`
 	Hostport           string `json:"hostport" ini:"hostport" yaml:"hostport"`
 	UserName           string `json:"username" ini:"username" yaml:"username"`
 	Password           string `json:"password" ini:"password" yaml:"password"`
 	Poolsize           int    `json:"poolsize" ini:"poolsize" yaml:"poolsize"`
 	Timeout            int    `json:"timeout" ini:"timeout" yaml:"timeout"`
 	SecondaryPreferred bool   `json:"enable_secondary_preferred" ini:"enable_secondary_preferred" yaml:"enable_secondary_preferred"`
+	// WaitPoolTimeout 等待并发令牌的超时时间，单位毫秒，默认1秒
+	WaitPoolTimeout int `json:"wait_pool_timeout" ini:"wait_pool_timeout" yaml:"wait_pool_timeout"`
+	// ReadTimeout、WriteTimeout、AggregateTimeout 各类操作的超时时间，单位秒，为0时使用Timeout
+	ReadTimeout      int `json:"read_timeout" ini:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout     int `json:"write_timeout" ini:"write_timeout" yaml:"write_timeout"`
+	AggregateTimeout int `json:"aggregate_timeout" ini:"aggregate_timeout" yaml:"aggregate_timeout"`
+
+	// TLS 开启TLS，设置了TLSCAFile、TLSCertFile、TLSInsecureSkipVerify时自动开启
+	TLS       bool   `json:"tls" ini:"tls" yaml:"tls"`
+	TLSCAFile string `json:"tls_ca_file" ini:"tls_ca_file" yaml:"tls_ca_file"`
+	// TLSCertFile、TLSKeyFile 客户端证书，TLSKeyFile为空时TLSCertFile需同时包含证书及私钥
+	TLSCertFile           string `json:"tls_cert_file" ini:"tls_cert_file" yaml:"tls_cert_file"`
+	TLSKeyFile            string `json:"tls_key_file" ini:"tls_key_file" yaml:"tls_key_file"`
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify" ini:"tls_insecure_skip_verify" yaml:"tls_insecure_skip_verify"`
+
+	AuthSource string `json:"auth_source" ini:"auth_source" yaml:"auth_source"`
+	// AuthMechanism 如SCRAM-SHA-256、MONGODB-X509，为空时由driver协商
+	AuthMechanism string `json:"auth_mechanism" ini:"auth_mechanism" yaml:"auth_mechanism"`
+
+	// WriteConcernW 数字、majority或tag名称
+	WriteConcernW string `json:"write_concern_w" ini:"write_concern_w" yaml:"write_concern_w"`
+	WriteConcernJ bool   `json:"write_concern_j" ini:"write_concern_j" yaml:"write_concern_j"`
+	// WriteConcernTimeout 单位毫秒
+	WriteConcernTimeout int `json:"write_concern_wtimeout" ini:"write_concern_wtimeout" yaml:"write_concern_wtimeout"`
+	// ReadConcern local、available、majority、linearizable、snapshot
+	ReadConcern string `json:"read_concern" ini:"read_concern" yaml:"read_concern"`
+
+	// ReadPreference primary、primaryPreferred、secondary、secondaryPreferred、nearest，与SecondaryPreferred同时设置时需一致
+	ReadPreference string `json:"read_preference" ini:"read_preference" yaml:"read_preference"`
+	// ReadPreferenceTags 多个tag set用;分隔，按顺序匹配，如dc:ny,rack:1;dc:sf
+	ReadPreferenceTags string `json:"read_preference_tags" ini:"read_preference_tags" yaml:"read_preference_tags"`
+	// MaxStaleness 单位秒，不小于90
+	MaxStaleness int `json:"max_staleness" ini:"max_staleness" yaml:"max_staleness"`
+
+	// Compressors zstd、snappy、zlib，按顺序与服务端协商
+	Compressors      []string `json:"compressors" ini:"compressors" yaml:"compressors"`
+	AppName          string   `json:"app_name" ini:"app_name" yaml:"app_name"`
+	ReplicaSet       string   `json:"replica_set" ini:"replica_set" yaml:"replica_set"`
+	DirectConnection bool     `json:"direct_connection" ini:"direct_connection" yaml:"direct_connection"`
+	MinPoolSize      int      `json:"min_pool_size" ini:"min_pool_size" yaml:"min_pool_size"`
+	// MaxConnIdleTime driver连接的最大空闲时间，单位秒，默认10分钟
+	MaxConnIdleTime int `json:"max_conn_idle_time" ini:"max_conn_idle_time" yaml:"max_conn_idle_time"`
 }
 
 type Client struct {
-	conn                   *mongo.Client
-	pool                   chan bool
+	conn                   atomic.Pointer[clientConn]
+	stopWatch              context.CancelFunc
+	pool                   *tokenPool
 	timeout                time.Duration
+	timeouts               timeoutPolicy
+	metrics                *clientMetrics
+	metricsSinks           []MetricsSink
+	slowLogger             *slowLogger
+	interceptors           []Interceptor
+	retryPolicy            *RetryPolicy
+	circuitBreaker         *CircuitBreakerConfig
+	breakers               sync.Map
+	driverMetrics          *driverMetrics
+	cursors                *cursorTracker
+	closeMu                sync.RWMutex
+	closed                 bool
+	inflight               sync.WaitGroup
+	retiring               sync.WaitGroup
+	retireStop             chan struct{}
+	tracerProvider         trace.TracerProvider
+	serverAddress          string
+	serverPort             int
 	metricTarget           string
 	metricsLabelConverters []func(label string) (newLabel string, hit bool)
 }
 
-var initMetricOnce sync.Once
+func InitClient(cfg Config, opts ...*options.ClientOptions) (client *Client, err error) {
+	return initClient(cfg, 2, WithDriverOptions(opts...))
+}
 
-var (
-	metricLatency *prometheus.HistogramVec
-	metricError   *prometheus.CounterVec
-)
+// InitClientWithOptions is same as InitClient, but accepts ClientOption to customize metrics etc.
+func InitClientWithOptions(cfg Config, opts ...ClientOption) (client *Client, err error) {
+	return initClient(cfg, 2, opts...)
+}
+
+func initClient(cfg Config, callerSkip int, opts ...ClientOption) (client *Client, err error) {
+	clientOpts := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&clientOpts)
+	}
 
-func InitClient(cfg Config, opts ...*options.ClientOptions) (client *Client, err error) {
 	// 矫正参数
 	timeout := time.Duration(cfg.Timeout) * time.Second
 	if timeout <= 0 {
 		timeout = DEFAULT_SOCKET_TIMEOUT
 	}
+	if clientOpts.credentialProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		cred, err := clientOpts.credentialProvider.Credential(ctx)
+		cancel()
+		if err != nil {
+			return nil, errors.Wrap(err, "CredentialProvider.Credential")
+		}
+		cfg = cred.apply(cfg)
+	}
+	if err = cfg.Validate(clientOpts.driverOptions...); err != nil {
+		return
+	}
 	poolsize := cfg.Poolsize
 	if poolsize <= 0 {
 		poolsize = DEFAULT_POOLSIZE
 	}
-	password := cfg.Password
-
-	option := options.Client().ApplyURI(cfg.Hostport)
-	option.SetConnectTimeout(timeout)
-	option.SetSocketTimeout(timeout)
-	option.SetMaxPoolSize(uint64(cfg.Poolsize) + 2)
-	option.SetMaxConnIdleTime(time.Minute * 10)
-
-	if cfg.UserName != "" {
-		option.SetAuth(options.Credential{
-			Username: cfg.UserName,
-			Password: password,
-		})
+	waitPoolTimeout := time.Duration(cfg.WaitPoolTimeout) * time.Millisecond
+	if waitPoolTimeout <= 0 {
+		waitPoolTimeout = DEFAULT_WAIT_POOL_TIMEOUT
 	}
-	if cfg.SecondaryPreferred {
-		option.SetReadPreference(readpref.SecondaryPreferred())
-	}
-	options := []*options.ClientOptions{option}
-	options = append(options, opts...)
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	conn, err := mongo.Connect(ctx, options...)
-	if err != nil {
-		return
+	metricTarget := cfg.Name
+	if metricTarget == "" && clientOpts.hostsAsName {
+		// 好多不规范的地方，把密码写到了明文的hostport中，只取其中的host部分
+		metricTarget = hostsFromURI(cfg.Hostport)
+	}
+	if metricTarget == "" {
+		// 未指定名称时，使用client实例的初始化位置来代替
+		_, file, line, _ := runtime.Caller(callerSkip)
+		if subs := strings.Split(file, "/"); len(subs) > 2 {
+			file = subs[len(subs)-2] + "/" + subs[len(subs)-1]
+		}
+		metricTarget = fmt.Sprintf("%s:%d", file, line)
 	}
 
-	// 提前ping一下，才能马上识别到后面的节点身份
-	err = conn.Ping(ctx, nil)
+	// 先注册metrics，失败时不必再建立连接
+	if clientOpts.disablePrometheus {
+		clientOpts.metric.registerer = prometheus.NewRegistry()
+	}
+	metrics, err := newClientMetrics(clientOpts.metric)
 	if err != nil {
 		return
 	}
-
-	pool := make(chan bool, poolsize)
-	for i := 0; i < poolsize; i++ {
-		pool <- true
+	var metricsSinks []MetricsSink
+	if !clientOpts.disablePrometheus {
+		metricsSinks = append(metricsSinks, metrics)
 	}
-	_, file, line, _ := runtime.Caller(1)
-	if subs := strings.Split(file, "/"); len(subs) > 2 {
-		file = subs[len(subs)-2] + "/" + subs[len(subs)-1]
+	if clientOpts.meterProvider != nil {
+		sink, err := NewOTelMetricsSink(clientOpts.meterProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewOTelMetricsSink")
+		}
+		metricsSinks = append(metricsSinks, sink)
 	}
-	client = &Client{
-		conn:    conn,
-		pool:    pool,
-		timeout: timeout,
+	metricsSinks = append(metricsSinks, clientOpts.metricsSinks...)
 
-		// 好多不规范的地方，把密码写到了明文的hostport中
-		// 所以这里的metric标识没敢用hostport，而是使用client实例的初始化位置来代替
-		metricTarget: fmt.Sprintf("%s:%d", file, line),
+	var monitorOptions []*options.ClientOptions
+	var monitor *driverMonitor
+	if clientOpts.driverMonitoring {
+		monitor, err = newDriverMonitor(clientOpts.metric, metricTarget)
+		if err != nil {
+			return
+		}
+		monitorOptions = append(monitorOptions, monitor.clientOptions(clientOpts.driverOptions))
 	}
+	cursors := newCursorTracker()
+	monitorOptions = append(monitorOptions,
+		cursors.clientOptions(append(clientOpts.driverOptions[:len(clientOpts.driverOptions):len(clientOpts.driverOptions)], monitorOptions...)))
 
-	initMetricOnce.Do(func() {
-		metricLatency, err = registMetrics(prometheus.NewHistogramVec(prometheus.HistogramOpts{
-			Namespace: "gomongodb",
-			Name:      "mongo_official_client_command_latency",
-			Help:      "Histogram of mongo request",
-			Buckets:   []float64{5, 15, 30, 50, 100, 300, 600, 1000, 2500, 5000, 10000},
-		}, []string{"target", "command", "db", "collection"}))
+	// connect 建立连接，更换凭证时也通过它重新连接
+	connect := func(ctx context.Context, cfg Config) (*mongo.Client, error) {
+		option, err := cfg.driverOptions(timeout, poolsize)
 		if err != nil {
-			err = errors.Wrap(err, "registMetrics")
-			return
+			return nil, err
 		}
-		metricError, err = registMetrics(prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: "gomongodb",
-			Name:      "mongo_official_client_command_error",
-			Help:      "Counter of mongo request error",
-		}, []string{"target", "command", "db", "collection"}))
+		driverOptions := []*options.ClientOptions{option}
+		driverOptions = append(driverOptions, clientOpts.driverOptions...)
+		driverOptions = append(driverOptions, monitorOptions...)
+		conn, err := mongo.Connect(ctx, driverOptions...)
 		if err != nil {
-			err = errors.Wrap(err, "registMetrics")
-			return
+			return nil, err
+		}
+
+		// 提前ping一下，才能马上识别到后面的节点身份
+		if err = conn.Ping(ctx, nil); err != nil {
+			_ = conn.Disconnect(ctx)
+			return nil, err
 		}
-	})
+		return conn, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := connect(ctx, cfg)
+	if err != nil {
+		return
+	}
+
+	client = &Client{
+		pool: newTokenPool(poolsize, waitPoolTimeout,
+			metrics.poolInUse.WithLabelValues(metricTarget), metrics.poolWaiting.WithLabelValues(metricTarget)),
+		timeout: timeout,
+		timeouts: timeoutPolicy{
+			read:           time.Duration(cfg.ReadTimeout) * time.Second,
+			write:          time.Duration(cfg.WriteTimeout) * time.Second,
+			aggregate:      time.Duration(cfg.AggregateTimeout) * time.Second,
+			callerDeadline: clientOpts.callerDeadline,
+		},
+		metrics:      metrics,
+		metricsSinks: metricsSinks,
+		metricTarget: metricTarget,
+
+		tracerProvider: clientOpts.tracerProvider,
+		interceptors:   clientOpts.interceptors,
+		retryPolicy:    clientOpts.retryPolicy,
+		circuitBreaker: clientOpts.circuitBreaker,
+		cursors:        cursors,
+	}
+	client.conn.Store(&clientConn{client: conn})
+	client.serverAddress, client.serverPort = serverAddressFromURI(cfg.Hostport)
+	if clientOpts.slowLog != nil {
+		client.slowLogger = newSlowLogger(*clientOpts.slowLog)
+	}
+	if monitor != nil {
+		monitor.client.Store(client)
+		client.driverMetrics = monitor.metrics
+	}
+	if clientOpts.credentialProvider != nil {
+		client.watchCredential(clientOpts.credentialProvider, cfg, connect)
+	}
 	return
 }
 
 // Client get mongo driver client
 func (f *Client) Client() *mongo.Client {
-	return f.conn
+	return f.conn.Load().client
+}
+
+// Name get the client name, used as metrics target label and trace attribute
+func (f *Client) Name() string {
+	return f.metricTarget
+}
+
+// acquire get a token from the client pool, the returned release must be called
+func (f *Client) acquire(ctx context.Context) (context.Context, func(), error) {
+	return f.pool.acquire(ctx, f.metricTarget)
 }
 
 // Timeout get configed timeout
@@ -182,12 +317,14 @@ func (f *Client) ScanCursor(ctx context.Context, cursor *mongo.Cursor, result in
 	return
 }
 
-// NewCollectionWrapper get collection operation wrapper, with a default waitPoolTimeout valued one second.
-func (f *Client) NewCollectionWrapper(database, collection string) CollectionWrapper {
+// NewCollectionWrapper get collection operation wrapper.
+// Every operation acquires a token from the client pool first, waiting at most Config.WaitPoolTimeout (default one second).
+func (f *Client) NewCollectionWrapper(database, collection string, opts ...WrapperOption) CollectionWrapper {
 	return &collectionWrapper{
-		client:     f,
-		database:   database,
-		collection: collection,
+		client:      f,
+		database:    database,
+		collection:  collection,
+		wrapperOpts: newWrapperOptions(opts),
 	}
 }
 
@@ -208,6 +345,12 @@ mongo commonds in fn must use sessCtx as its ctx param
 */
 func (f *Client) DoTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) (
 	interface{}, error), opts ...*options.TransactionOptions) (res interface{}, err error) {
+	ctx, done, err := f.begin(ctx)
+	if err != nil {
+		return
+	}
+	defer done()
+
 	session, err := f.Client().StartSession()
 	if err != nil {
 		err = errors.Wrap(err, "StartSession")
@@ -244,31 +387,12 @@ func (f *Client) convertMetricsLabel(label string) string {
 	return label
 }
 
-func registMetrics[T prometheus.Collector](collector T) (T, error) {
-	var null T
-	err := prometheus.DefaultRegisterer.Register(collector)
-	if err == nil {
-		return collector, nil
-	}
-
-	if arErr, ok := err.(prometheus.AlreadyRegisteredError); ok {
-		already, ok := arErr.ExistingCollector.(T)
-		if ok {
-			return already, nil
-		} else {
-			return null, errors.Errorf("ExistingCollector, want %v, but %v",
-				reflect.TypeOf(null), reflect.TypeOf(arErr.ExistingCollector))
-		}
-	}
-
-	return null, err
-}
-
-func NewCollectionWrapper[T any](client *Client, database, collection string) CollectionWrapperGeneric[T] {
+func NewCollectionWrapper[T any](client *Client, database, collection string, opts ...WrapperOption) CollectionWrapperGeneric[T] {
 	wrapper := &collectionWrapper{
-		client:     client,
-		database:   database,
-		collection: collection,
+		client:      client,
+		database:    database,
+		collection:  collection,
+		wrapperOpts: newWrapperOptions(opts),
 	}
 	return &collectionWrapperGeneric[T]{
 		collectionWrapper: wrapper,
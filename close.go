@@ -0,0 +1,309 @@
+package gomongodb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrClientClosed Client已调用Close，不再接受新的操作
+var ErrClientClosed = errors.New("gomongodb: client closed")
+
+type ctxKeyInflight struct{}
+
+// begin 登记一个进行中的操作，返回的done必需调用。
+// 返回的ctx带有进行中的标记，UseSession、DoTransaction闭包中的嵌套调用在Close期间仍可执行。
+func (f *Client) begin(ctx context.Context) (context.Context, func(), error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Value(ctxKeyInflight{}) == f {
+		return ctx, func() {}, nil
+	}
+	f.closeMu.RLock()
+	defer f.closeMu.RUnlock()
+	if f.closed {
+		return ctx, nil, ErrClientClosed
+	}
+	// 更换凭证时，旧连接需等待在其上开始的操作结束
+	conn := f.conn.Load()
+	conn.inflight.Add(1)
+	f.inflight.Add(1)
+	done := func() {
+		conn.inflight.Done()
+		f.inflight.Done()
+	}
+	return context.WithValue(ctx, ctxKeyInflight{}, f), done, nil
+}
+
+/*
+Close 优雅关闭Client，适用于收到SIGTERM等场景：
+
+1. 新的wrapper操作返回ErrClientClosed；
+
+2. 等待进行中的操作，以及FindCursor返回的游标被读完、关闭或被服务端因空闲回收，直到ctx结束；
+
+3. 断开driver的连接，包括更换凭证后尚未断开的旧连接，并删除该Client的metrics。
+
+ctx结束时仍未完成的操作会被中断，此时返回等待的错误。重复调用返回ErrClientClosed。
+*/
+func (f *Client) Close(ctx context.Context) error {
+	f.closeMu.Lock()
+	if f.closed {
+		f.closeMu.Unlock()
+		return ErrClientClosed
+	}
+	f.closed = true
+	f.closeMu.Unlock()
+	if f.stopWatch != nil {
+		f.stopWatch()
+	}
+
+	drainErr := f.drain(ctx)
+	f.stopRetiring()
+	err := f.Client().Disconnect(ctx)
+	f.unregisterMetrics()
+	if drainErr != nil {
+		return drainErr
+	}
+	return errors.Wrap(err, "Disconnect")
+}
+
+func (f *Client) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait in-flight operations")
+	}
+	return errors.Wrap(f.cursors.wait(ctx, nil), "wait open cursors")
+}
+
+// unregisterMetrics 删除该Client的metrics，collector由相同Registerer下的Client共用，不做Unregister
+func (f *Client) unregisterMetrics() {
+	labels := prometheus.Labels{"target": f.metricTarget}
+	m := f.metrics
+	m.latency.DeletePartialMatch(labels)
+	m.errors.DeletePartialMatch(labels)
+	m.poolInUse.DeletePartialMatch(labels)
+	m.poolWaiting.DeletePartialMatch(labels)
+	m.returned.DeletePartialMatch(labels)
+	m.affected.DeletePartialMatch(labels)
+	m.retries.DeletePartialMatch(labels)
+	m.circuitState.DeletePartialMatch(labels)
+	if f.driverMetrics != nil {
+		f.driverMetrics.deleteTarget(labels)
+	}
+}
+
+func (m *driverMetrics) deleteTarget(labels prometheus.Labels) {
+	m.commandLatency.DeletePartialMatch(labels)
+	m.commandError.DeletePartialMatch(labels)
+	m.checkoutWait.DeletePartialMatch(labels)
+	m.poolSize.DeletePartialMatch(labels)
+	m.poolInUse.DeletePartialMatch(labels)
+	m.connectionError.DeletePartialMatch(labels)
+	m.heartbeatRTT.DeletePartialMatch(labels)
+	m.heartbeatError.DeletePartialMatch(labels)
+}
+
+// cursorKey cursor id只在单个server上唯一，副本集、分片集群中需同时区分server的地址
+type cursorKey struct {
+	address string
+	id      int64
+}
+
+type trackedCursor struct {
+	// conn 游标所在的连接，更换凭证后旧连接需等待其上的游标结束
+	conn     *clientConn
+	lastUsed time.Time
+}
+
+type ctxKeyTrackCursor struct{}
+
+// trackCursor 标记ctx下的find命令返回的游标需要登记，conn为执行命令的连接
+func trackCursor(ctx context.Context, conn *clientConn) context.Context {
+	return context.WithValue(ctx, ctxKeyTrackCursor{}, conn)
+}
+
+// cursorTracker 记录FindCursor返回的未读完的游标。
+// mongo.Cursor无法感知关闭，也不暴露所在的server，通过command monitor观察：
+// FindCursor的find命令返回的cursor id不为0时登记，getMore返回的cursor id为0、killCursors、getMore返回CursorNotFound时移除。
+// 超过服务端空闲超时（cursorTimeoutMillis，默认10分钟）没有getMore的游标已被服务端回收，不再等待。
+type cursorTracker struct {
+	mu          sync.Mutex
+	open        map[cursorKey]*trackedCursor
+	idleTimeout time.Duration
+	// commands 进行中的find、getMore命令，RequestID -> pendingCursorCommand
+	commands sync.Map
+}
+
+type pendingCursorCommand struct {
+	// key getMore的游标
+	key cursorKey
+	// conn FindCursor的find命令执行的连接
+	conn *clientConn
+}
+
+func newCursorTracker() *cursorTracker {
+	return &cursorTracker{open: make(map[cursorKey]*trackedCursor), idleTimeout: DEFAULT_CURSOR_IDLE_TIMEOUT}
+}
+
+func (t *cursorTracker) add(key cursorKey, conn *clientConn) {
+	t.mu.Lock()
+	t.open[key] = &trackedCursor{conn: conn, lastUsed: time.Now()}
+	t.mu.Unlock()
+}
+
+func (t *cursorTracker) touch(key cursorKey) {
+	t.mu.Lock()
+	if c, ok := t.open[key]; ok {
+		c.lastUsed = time.Now()
+	}
+	t.mu.Unlock()
+}
+
+func (t *cursorTracker) remove(keys ...cursorKey) {
+	t.mu.Lock()
+	for _, key := range keys {
+		delete(t.open, key)
+	}
+	t.mu.Unlock()
+}
+
+// len conn上未结束的游标数，conn为nil时统计所有连接。同时清理已被服务端回收的游标
+func (t *cursorTracker) len(conn *clientConn) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for key, c := range t.open {
+		if time.Since(c.lastUsed) > t.idleTimeout {
+			delete(t.open, key)
+			continue
+		}
+		if conn == nil || c.conn == conn {
+			n++
+		}
+	}
+	return n
+}
+
+// wait 等待conn上的游标结束，conn为nil时等待所有连接
+func (t *cursorTracker) wait(ctx context.Context, conn *clientConn) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for t.len(conn) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// clientOptions 生成安装command monitor的driver options，需放在opts的最后，opts中已有的monitor会被串联调用
+func (t *cursorTracker) clientOptions(opts []*options.ClientOptions) *options.ClientOptions {
+	user := &event.CommandMonitor{}
+	for _, opt := range opts {
+		if opt != nil && opt.Monitor != nil {
+			user = opt.Monitor
+		}
+	}
+	return options.Client().SetMonitor(&event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			t.observeStarted(ctx, e)
+			if user.Started != nil {
+				user.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			t.observeSucceeded(e)
+			if user.Succeeded != nil {
+				user.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			t.observeFailed(e)
+			if user.Failed != nil {
+				user.Failed(ctx, e)
+			}
+		},
+	})
+}
+
+func (t *cursorTracker) observeStarted(ctx context.Context, e *event.CommandStartedEvent) {
+	address := connectionHost(e.ConnectionID)
+	switch e.CommandName {
+	case "find":
+		if conn, ok := ctx.Value(ctxKeyTrackCursor{}).(*clientConn); ok {
+			t.commands.Store(e.RequestID, pendingCursorCommand{key: cursorKey{address: address}, conn: conn})
+		}
+	case "getMore":
+		if id, ok := e.Command.Lookup("getMore").Int64OK(); ok {
+			t.commands.Store(e.RequestID, pendingCursorCommand{key: cursorKey{address: address, id: id}})
+		}
+	case "killCursors":
+		cursors, ok := e.Command.Lookup("cursors").ArrayOK()
+		if !ok {
+			return
+		}
+		values, err := cursors.Values()
+		if err != nil {
+			return
+		}
+		for _, v := range values {
+			if id, ok := v.Int64OK(); ok {
+				t.remove(cursorKey{address: address, id: id})
+			}
+		}
+	}
+}
+
+func (t *cursorTracker) observeSucceeded(e *event.CommandSucceededEvent) {
+	v, ok := t.commands.LoadAndDelete(e.RequestID)
+	if !ok {
+		return
+	}
+	cmd := v.(pendingCursorCommand)
+	var reply struct {
+		Cursor struct {
+			ID int64 `bson:"id"`
+		} `bson:"cursor"`
+	}
+	if err := bson.Unmarshal(e.Reply, &reply); err != nil {
+		return
+	}
+	switch {
+	case cmd.conn != nil:
+		if reply.Cursor.ID != 0 {
+			t.add(cursorKey{address: cmd.key.address, id: reply.Cursor.ID}, cmd.conn)
+		}
+	case reply.Cursor.ID == 0:
+		t.remove(cmd.key)
+	default:
+		t.touch(cmd.key)
+	}
+}
+
+func (t *cursorTracker) observeFailed(e *event.CommandFailedEvent) {
+	v, ok := t.commands.LoadAndDelete(e.RequestID)
+	if !ok {
+		return
+	}
+	// 游标已被服务端回收，如空闲超时
+	if cmd := v.(pendingCursorCommand); cmd.conn == nil && strings.Contains(e.Failure, "CursorNotFound") {
+		t.remove(cmd.key)
+	}
+}
@@ -0,0 +1,125 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func TestClient_begin(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	client.cursors = newCursorTracker()
+
+	ctx, done, err := client.begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.closed = true
+
+	// 进行中的操作里的嵌套调用不受影响
+	if _, nestedDone, err := client.begin(ctx); err != nil {
+		t.Fatalf("nested begin() error = %v", err)
+	} else {
+		nestedDone()
+	}
+	if _, _, err := client.begin(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("begin() error = %v, want ErrClientClosed", err)
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.drain(drainCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain() error = %v, want DeadlineExceeded", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done()
+	}()
+	if err := client.drain(context.Background()); err != nil {
+		t.Fatalf("drain() error = %v", err)
+	}
+}
+
+func Test_cursorTracker(t *testing.T) {
+	tracker := newCursorTracker()
+	conn := &clientConn{}
+	monitor := tracker.clientOptions(nil).Monitor
+
+	raw := func(v interface{}) bson.Raw {
+		b, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	succeeded := func(name string, requestID int64, address string, reply interface{}) {
+		monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{
+			CommandFinishedEvent: event.CommandFinishedEvent{CommandName: name, RequestID: requestID, ConnectionID: address + "[-1]"},
+			Reply:                raw(reply),
+		})
+	}
+	find := func(ctx context.Context, requestID int64, address string, cursorID int64) {
+		monitor.Started(ctx, &event.CommandStartedEvent{
+			Command:      raw(bson.D{{Key: "find", Value: "col"}}),
+			CommandName:  "find",
+			RequestID:    requestID,
+			ConnectionID: address + "[-1]",
+		})
+		succeeded("find", requestID, address, bson.M{"cursor": bson.M{"id": cursorID}})
+	}
+	getMore := func(requestID int64, address string, cursorID int64) {
+		monitor.Started(context.Background(), &event.CommandStartedEvent{
+			Command:      raw(bson.D{{Key: "getMore", Value: cursorID}, {Key: "collection", Value: "col"}}),
+			CommandName:  "getMore",
+			RequestID:    requestID,
+			ConnectionID: address + "[-1]",
+		})
+	}
+
+	// 只登记FindCursor的find，cursor id在不同server上可能相同
+	ctx := trackCursor(context.Background(), conn)
+	find(ctx, 1, "a:27017", 1)
+	find(ctx, 2, "b:27017", 1)
+	find(ctx, 3, "a:27017", 2)
+	find(ctx, 4, "a:27017", 3)
+	find(ctx, 5, "a:27017", 0)
+	find(context.Background(), 6, "a:27017", 4)
+	if n := tracker.len(nil); n != 4 {
+		t.Fatalf("open cursors = %v, want 4", tracker.open)
+	}
+
+	// 游标读完
+	getMore(10, "a:27017", 1)
+	succeeded("getMore", 10, "a:27017", bson.M{"cursor": bson.M{"id": 0}})
+	// 游标被服务端回收
+	getMore(11, "a:27017", 2)
+	monitor.Failed(context.Background(), &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "getMore", RequestID: 11},
+		Failure:              "(CursorNotFound) cursor id 2 not found",
+	})
+	monitor.Started(context.Background(), &event.CommandStartedEvent{
+		Command:      raw(bson.D{{Key: "killCursors", Value: "col"}, {Key: "cursors", Value: bson.A{int64(3)}}}),
+		CommandName:  "killCursors",
+		RequestID:    12,
+		ConnectionID: "a:27017[-2]",
+	})
+	if _, ok := tracker.open[cursorKey{address: "b:27017", id: 1}]; !ok || tracker.len(nil) != 1 || tracker.len(conn) != 1 || tracker.len(&clientConn{}) != 0 {
+		t.Fatalf("open cursors = %v, want only b:27017/1", tracker.open)
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tracker.wait(waitCtx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait() error = %v", err)
+	}
+	// 超过空闲超时的游标已被服务端回收
+	tracker.idleTimeout = 10 * time.Millisecond
+	if err := tracker.wait(context.Background(), nil); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+}
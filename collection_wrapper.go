@@ -2,14 +2,7 @@ package gomongodb
 
 import (
 	"context"
-	"runtime"
 	"strings"
-	"time"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/trace"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -23,7 +16,7 @@ type CollectionWrapperBase interface {
 	// GenSortBson translate sort keys like [-_id, cnt, +ut] to bson.D
 	GenSortBson(sort []string) (result bson.D)
 
-	// FindCursor 返回官方的cursor，注意通过这个cursor读取数据，会脱离metrics监控。只有当需要读取大量数据，Find会超时时，才用FindCursor。
+	// FindCursor 返回官方的cursor，注意通过这个cursor读取数据，会脱离metrics监控。只有当需要读取大量数据，Find会超时时，才用FindCursor，泛型wrapper优先使用Stream、ForEach。FindCursor返回后即归还并发令牌，游标的读取不受poolsize限制。游标需读完或关闭，否则Client.Close会等待到超时或服务端回收空闲游标。
 	FindCursor(ctx context.Context, filter interface{}, sort []string, skip, limit int64, opts ...*options.FindOptions) (cursor *mongo.Cursor, err error)
 
 	// UpdateOne
@@ -97,12 +90,24 @@ type CollectionWrapper interface {
 	InsertMany(ctx context.Context, document []interface{}, opts ...*options.InsertManyOptions) (insertedIDs []interface{}, err error)
 }
 
+// ProjectionFinder 只返回部分字段的查询，NewCollectionWrapper、NewCollectionWrapperGeneric返回的wrapper均已实现，
+// 泛型wrapper优先使用FindAs、FindOneAs。独立于CollectionWrapperBase，不影响其他CollectionWrapperBase的实现。
+type ProjectionFinder interface {
+	// FindProjection 同Find，只返回projection中的字段，result为切片的指针。projection优先于opts中的Projection
+	FindProjection(ctx context.Context, filter, projection, result interface{}, sort []string, skip, limit int64, opts ...*options.FindOptions) (err error)
+
+	// FindOneProjection 同FindOne，只返回projection中的字段
+	FindOneProjection(ctx context.Context, filter, projection, result interface{}, sort []string, skip int64, opts ...*options.FindOneOptions) (has bool, err error)
+}
+
 var _ CollectionWrapper = &collectionWrapper{}
+var _ ProjectionFinder = &collectionWrapper{}
 
 type collectionWrapper struct {
-	client     *Client
-	database   string
-	collection string
+	client      *Client
+	database    string
+	collection  string
+	wrapperOpts wrapperOptions
 }
 
 func (c *collectionWrapper) GenSortBson(sort []string) (result bson.D) {
@@ -126,658 +131,439 @@ func (c *collectionWrapper) Collection() *mongo.Collection {
 func (c *collectionWrapper) FindCursor(ctx context.Context, filter interface{},
 	sort []string, skip, limit int64, opts ...*options.FindOptions) (cursor *mongo.Cursor, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	opt := options.Find().SetSkip(skip).SetLimit(limit)
-	if len(sort) > 0 {
-		opt.SetSort(c.GenSortBson(sort))
-	}
-	opts = append(opts, opt)
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "find_cursor")
-	defer span.End()
-
-	cursor, err = conn.Database(c.database).Collection(c.collection).Find(ctx, filter, opts...)
-	if err != nil {
+	op := c.newOperation("FindCursor", "find")
+	op.Filter, op.Sort, op.Skip, op.Limit, op.Options, op.Result = filter, sort, skip, limit, opts, &cursor
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		opt := options.Find().SetSkip(op.Skip).SetLimit(op.Limit)
+		if len(op.Sort) > 0 {
+			opt.SetSort(c.GenSortBson(op.Sort))
+		}
+		// 游标由command monitor在find返回时登记，Client.Close会等待其结束
+		conn := c.client.conn.Load()
+		cursor, err = conn.client.Database(c.database).Collection(c.collection).Find(trackCursor(ctx, conn), op.Filter,
+			append(opts[:len(opts):len(opts)], opt)...)
 		return
-	}
+	})
 	return
 }
 
 func (c *collectionWrapper) Find(ctx context.Context, filter interface{}, result interface{},
 	sort []string, skip, limit int64, opts ...*options.FindOptions) (err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
+	op := c.newOperation("Find", "find")
+	op.Filter, op.Sort, op.Skip, op.Limit, op.Options, op.Result = filter, sort, skip, limit, opts, result
+	return c.invoke(ctx, op, c.findInvoker(opts))
+}
 
-	opt := options.Find().SetSkip(skip).SetLimit(limit)
-	if len(sort) > 0 {
-		opt.SetSort(c.GenSortBson(sort))
-	}
-	opts = append(opts, opt)
+func (c *collectionWrapper) FindProjection(ctx context.Context, filter, projection, result interface{},
+	sort []string, skip, limit int64, opts ...*options.FindOptions) (err error) {
 
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "find")
-	defer span.End()
+	op := c.newOperation("FindProjection", "find")
+	op.Filter, op.Projection, op.Sort, op.Skip, op.Limit, op.Options, op.Result = filter, projection, sort, skip, limit, opts, result
+	return c.invoke(ctx, op, c.findInvoker(opts))
+}
 
-	cursor, err := conn.Database(c.database).Collection(c.collection).Find(ctx, filter, opts...)
-	if err != nil {
-		return
-	}
-	err = c.client.ScanCursor(ctx, cursor, result)
-	if err != nil {
+func (c *collectionWrapper) findInvoker(opts []*options.FindOptions) Invoker {
+	return func(ctx context.Context, op *Operation) (err error) {
+		opt := options.Find().SetSkip(op.Skip).SetLimit(op.Limit)
+		if len(op.Sort) > 0 {
+			opt.SetSort(c.GenSortBson(op.Sort))
+		}
+		if op.Projection != nil {
+			opt.SetProjection(op.Projection)
+		}
+		cursor, err := c.Collection().Find(ctx, op.Filter, append(prependMaxTime(op, opts, options.Find().SetMaxTime(op.maxTime)), opt)...)
+		if err != nil {
+			return
+		}
+		err = c.client.ScanCursor(ctx, cursor, op.Result)
+		if err != nil {
+			return
+		}
+		op.DocumentsReturned = sliceLen(op.Result)
 		return
 	}
-	return
 }
 
 func (c *collectionWrapper) FindOne(ctx context.Context, filter interface{}, result interface{},
 	sort []string, skip int64, opts ...*options.FindOneOptions) (has bool, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	return c.findOne(ctx, filter, result, sort, skip, opts...)
+	op := c.newOperation("FindOne", "find")
+	op.Filter, op.Sort, op.Skip, op.Options, op.Result = filter, sort, skip, opts, result
+	err = c.invoke(ctx, op, c.findOneInvoker(opts))
+	has = op.DocumentsReturned > 0
+	return
 }
 
-func (c *collectionWrapper) findOne(ctx context.Context, filter interface{}, result interface{},
+func (c *collectionWrapper) FindOneProjection(ctx context.Context, filter, projection, result interface{},
 	sort []string, skip int64, opts ...*options.FindOneOptions) (has bool, err error) {
 
-	opt := options.FindOne().SetSkip(skip)
-	if len(sort) > 0 {
-		opt.SetSort(c.GenSortBson(sort))
-	}
-	opts = append(opts, opt)
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "findOne")
-	defer span.End()
-
-	err = conn.Database(c.database).Collection(c.collection).FindOne(ctx, filter, opts...).Decode(result)
-	if err == mongo.ErrNoDocuments {
-		has, err = false, nil
-		return
-	}
-	if err != nil {
-		return
-	}
-	has = true
+	op := c.newOperation("FindOneProjection", "find")
+	op.Filter, op.Projection, op.Sort, op.Skip, op.Options, op.Result = filter, projection, sort, skip, opts, result
+	err = c.invoke(ctx, op, c.findOneInvoker(opts))
+	has = op.DocumentsReturned > 0
 	return
 }
 
 func (c *collectionWrapper) FindID(ctx context.Context, ID interface{}, result interface{},
 	opts ...*options.FindOneOptions) (has bool, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
+	op := c.newOperation("FindID", "find")
+	op.Filter, op.Options, op.Result = bson.M{"_id": ID}, opts, result
+	err = c.invoke(ctx, op, c.findOneInvoker(opts))
+	has = op.DocumentsReturned > 0
+	return
+}
 
-	filter := bson.M{"_id": ID}
-	return c.findOne(ctx, filter, result, nil, 0, opts...)
+func (c *collectionWrapper) findOneInvoker(opts []*options.FindOneOptions) Invoker {
+	return func(ctx context.Context, op *Operation) (err error) {
+		opt := options.FindOne().SetSkip(op.Skip)
+		if len(op.Sort) > 0 {
+			opt.SetSort(c.GenSortBson(op.Sort))
+		}
+		if op.Projection != nil {
+			opt.SetProjection(op.Projection)
+		}
+		err = c.Collection().FindOne(ctx, op.Filter, append(prependMaxTime(op, opts, options.FindOne().SetMaxTime(op.maxTime)), opt)...).Decode(op.Result)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		op.DocumentsReturned = 1
+		return
+	}
 }
 
 func (c *collectionWrapper) FindOneAndUpdate(ctx context.Context, filter, update, result interface{},
 	sort []string, upsert, returnNew bool, opts ...*options.FindOneAndUpdateOptions) (has bool, err error) {
 
-	if err := updateSafeCheck(update); err != nil {
-		return false, err
-	}
-
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	opt := options.FindOneAndUpdate()
-	if len(sort) > 0 {
-		opt.SetSort(c.GenSortBson(sort))
-	}
-	opt.SetUpsert(upsert)
-	if returnNew {
-		opt.SetReturnDocument(options.After)
-	} else {
-		opt.SetReturnDocument(options.Before)
-	}
-	opts = append(opts, opt)
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "FindOneAndUpdate")
-	defer span.End()
-
-	err = conn.Database(c.database).Collection(c.collection).FindOneAndUpdate(ctx, filter, update, opts...).Decode(result)
-	if err == mongo.ErrNoDocuments {
-		has, err = false, nil
-		return
-	}
-	if err != nil {
+	op := c.newOperation("FindOneAndUpdate", "findAndModify")
+	op.Filter, op.Update, op.Sort, op.Options, op.Result = filter, update, sort, opts, result
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		if err = updateSafeCheck(op.Update); err != nil {
+			return &UpdateRejectedError{Reason: err}
+		}
+		opt := options.FindOneAndUpdate()
+		if len(op.Sort) > 0 {
+			opt.SetSort(c.GenSortBson(op.Sort))
+		}
+		opt.SetUpsert(upsert)
+		if returnNew {
+			opt.SetReturnDocument(options.After)
+		} else {
+			opt.SetReturnDocument(options.Before)
+		}
+		err = c.Collection().FindOneAndUpdate(ctx, op.Filter, op.Update,
+			append(prependMaxTime(op, opts, options.FindOneAndUpdate().SetMaxTime(op.maxTime)), opt)...).Decode(op.Result)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		op.DocumentsReturned, op.DocumentsAffected = 1, 1
 		return
-	}
-	has = true
+	})
+	has = op.DocumentsReturned > 0
 	return
 }
 
 func (c *collectionWrapper) FindOneAndReplace(ctx context.Context, filter, replacement, result interface{},
 	sort []string, upsert, returnNew bool, opts ...*options.FindOneAndReplaceOptions) (has bool, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	opt := options.FindOneAndReplace()
-	if len(sort) > 0 {
-		opt.SetSort(c.GenSortBson(sort))
-	}
-	opt.SetUpsert(upsert)
-	if returnNew {
-		opt.SetReturnDocument(options.After)
-	} else {
-		opt.SetReturnDocument(options.Before)
-	}
-	opts = append(opts, opt)
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "FindOneAndReplace")
-	defer span.End()
-
-	err = conn.Database(c.database).Collection(c.collection).FindOneAndReplace(ctx, filter, replacement, opts...).Decode(result)
-	if err == mongo.ErrNoDocuments {
-		has, err = false, nil
-		return
-	}
-	if err != nil {
+	op := c.newOperation("FindOneAndReplace", "findAndModify")
+	op.Filter, op.Update, op.Sort, op.Options, op.Result = filter, replacement, sort, opts, result
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		opt := options.FindOneAndReplace()
+		if len(op.Sort) > 0 {
+			opt.SetSort(c.GenSortBson(op.Sort))
+		}
+		opt.SetUpsert(upsert)
+		if returnNew {
+			opt.SetReturnDocument(options.After)
+		} else {
+			opt.SetReturnDocument(options.Before)
+		}
+		err = c.Collection().FindOneAndReplace(ctx, op.Filter, op.Update,
+			append(prependMaxTime(op, opts, options.FindOneAndReplace().SetMaxTime(op.maxTime)), opt)...).Decode(op.Result)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		op.DocumentsReturned, op.DocumentsAffected = 1, 1
 		return
-	}
-	has = true
+	})
+	has = op.DocumentsReturned > 0
 	return
 }
 
 func (c *collectionWrapper) FindOneAndDelete(ctx context.Context, filter, result interface{},
 	sort []string, opts ...*options.FindOneAndDeleteOptions) (has bool, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	opt := options.FindOneAndDelete()
-	if len(sort) > 0 {
-		opt.SetSort(c.GenSortBson(sort))
-	}
-	opts = append(opts, opt)
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "FindOneAndDelete")
-	defer span.End()
-
-	err = conn.Database(c.database).Collection(c.collection).FindOneAndDelete(ctx, filter, opts...).Decode(result)
-	if err == mongo.ErrNoDocuments {
-		has, err = false, nil
-		return
-	}
-	if err != nil {
+	op := c.newOperation("FindOneAndDelete", "findAndModify")
+	op.Filter, op.Sort, op.Options, op.Result = filter, sort, opts, result
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		opt := options.FindOneAndDelete()
+		if len(op.Sort) > 0 {
+			opt.SetSort(c.GenSortBson(op.Sort))
+		}
+		err = c.Collection().FindOneAndDelete(ctx, op.Filter,
+			append(prependMaxTime(op, opts, options.FindOneAndDelete().SetMaxTime(op.maxTime)), opt)...).Decode(op.Result)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		op.DocumentsReturned, op.DocumentsAffected = 1, 1
 		return
-	}
-	has = true
+	})
+	has = op.DocumentsReturned > 0
 	return
 }
 
 func (c *collectionWrapper) InsertOne(ctx context.Context, document interface{},
 	opts ...*options.InsertOneOptions) (insertedID interface{}, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "InsertOne")
-	defer span.End()
-
-	result, err := conn.Database(c.database).Collection(c.collection).InsertOne(ctx, document, opts...)
-	if err != nil {
+	op := c.newOperation("InsertOne", "insert")
+	op.Documents, op.Options, op.Result = []interface{}{document}, opts, &insertedID
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		result, err := c.Collection().InsertOne(ctx, op.Documents[0], opts...)
+		if err != nil {
+			return
+		}
+		insertedID = result.InsertedID
+		op.DocumentsAffected = 1
 		return
-	}
-	insertedID = result.InsertedID
+	})
 	return
 }
 
 func (c *collectionWrapper) InsertMany(ctx context.Context, document []interface{},
 	opts ...*options.InsertManyOptions) (insertedIDs []interface{}, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "InsertMany")
-	defer span.End()
-
-	result, err := conn.Database(c.database).Collection(c.collection).InsertMany(ctx, document, opts...)
-	if err != nil {
+	op := c.newOperation("InsertMany", "insert")
+	op.Documents, op.Options, op.Result = document, opts, &insertedIDs
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		result, err := c.Collection().InsertMany(ctx, op.Documents, opts...)
+		if err != nil {
+			return
+		}
+		insertedIDs = result.InsertedIDs
+		op.DocumentsAffected = int64(len(insertedIDs))
 		return
-	}
-	insertedIDs = result.InsertedIDs
+	})
 	return
 }
 
 func (c *collectionWrapper) UpdateOne(ctx context.Context, filter, update interface{},
 	upsert bool, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	return c.updateOne(ctx, filter, update, upsert, opts...)
+	op := c.newOperation("UpdateOne", "update")
+	op.Filter, op.Update, op.Options, op.Result = filter, update, opts, &result
+	err = c.invoke(ctx, op, c.updateInvoker(upsert, false, opts, &result))
+	return
 }
 
 func (c *collectionWrapper) UpdateID(ctx context.Context, ID, update interface{},
 	upsert bool, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	filter := bson.M{"_id": ID}
-	return c.updateOne(ctx, filter, update, upsert, opts...)
+	op := c.newOperation("UpdateID", "update")
+	op.Filter, op.Update, op.Options, op.Result = bson.M{"_id": ID}, update, opts, &result
+	err = c.invoke(ctx, op, c.updateInvoker(upsert, false, opts, &result))
+	return
 }
 
-func (c *collectionWrapper) updateOne(ctx context.Context, filter, update interface{},
+func (c *collectionWrapper) UpdateMany(ctx context.Context, filter, update interface{},
 	upsert bool, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
 
-	if err := updateSafeCheck(update); err != nil {
-		return nil, err
-	}
-
-	opt := options.Update()
-	opt.SetUpsert(upsert)
-	opts = append(opts, opt)
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "UpdateOne")
-	defer span.End()
-
-	resultOri, err := conn.Database(c.database).Collection(c.collection).UpdateOne(ctx, filter, update, opts...)
-	if err != nil {
-		return
-	}
-
-	result = &mongo.UpdateResult{
-		MatchedCount:  resultOri.MatchedCount,
-		ModifiedCount: resultOri.ModifiedCount,
-		UpsertedCount: resultOri.UpsertedCount,
-		UpsertedID:    resultOri.UpsertedID,
-	}
+	op := c.newOperation("UpdateMany", "update")
+	op.Filter, op.Update, op.Options, op.Result = filter, update, opts, &result
+	err = c.invoke(ctx, op, c.updateInvoker(upsert, true, opts, &result))
 	return
 }
 
-func (c *collectionWrapper) UpdateMany(ctx context.Context, filter, update interface{},
-	upsert bool, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
-
-	if err := updateSafeCheck(update); err != nil {
-		return nil, err
-	}
+func (c *collectionWrapper) updateInvoker(upsert, many bool, opts []*options.UpdateOptions,
+	result **mongo.UpdateResult) Invoker {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
+	return func(ctx context.Context, op *Operation) (err error) {
+		if err = updateSafeCheck(op.Update); err != nil {
+			return &UpdateRejectedError{Reason: err}
+		}
 
-	opt := options.Update()
-	opt.SetUpsert(upsert)
-	opts = append(opts, opt)
+		opt := options.Update()
+		opt.SetUpsert(upsert)
+		updateOpts := append(opts[:len(opts):len(opts)], opt)
 
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "UpdateMany")
-	defer span.End()
+		var resultOri *mongo.UpdateResult
+		if many {
+			resultOri, err = c.Collection().UpdateMany(ctx, op.Filter, op.Update, updateOpts...)
+		} else {
+			resultOri, err = c.Collection().UpdateOne(ctx, op.Filter, op.Update, updateOpts...)
+		}
+		if err != nil {
+			return
+		}
 
-	resultOri, err := conn.Database(c.database).Collection(c.collection).UpdateMany(ctx, filter, update, opts...)
-	if err != nil {
+		*result = &mongo.UpdateResult{
+			MatchedCount:  resultOri.MatchedCount,
+			ModifiedCount: resultOri.ModifiedCount,
+			UpsertedCount: resultOri.UpsertedCount,
+			UpsertedID:    resultOri.UpsertedID,
+		}
+		op.DocumentsAffected = resultOri.ModifiedCount + resultOri.UpsertedCount
 		return
 	}
-
-	result = &mongo.UpdateResult{
-		MatchedCount:  resultOri.MatchedCount,
-		ModifiedCount: resultOri.ModifiedCount,
-		UpsertedCount: resultOri.UpsertedCount,
-		UpsertedID:    resultOri.UpsertedID,
-	}
-	return
 }
 
 func (c *collectionWrapper) Count(ctx context.Context, filter interface{}, skip, limit int64,
 	opts ...*options.CountOptions) (count int64, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	opt := options.Count().SetSkip(skip)
-	if limit > 0 {
-		opt.SetLimit(limit)
-	}
-	opts = append(opts, opt)
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "Count")
-	defer span.End()
-
-	count, err = conn.Database(c.database).Collection(c.collection).CountDocuments(ctx, filter, opts...)
-	if err != nil {
+	op := c.newOperation("Count", "aggregate")
+	op.Filter, op.Skip, op.Limit, op.Options, op.Result = filter, skip, limit, opts, &count
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		opt := options.Count().SetSkip(op.Skip)
+		if op.Limit > 0 {
+			opt.SetLimit(op.Limit)
+		}
+		count, err = c.Collection().CountDocuments(ctx, op.Filter, append(prependMaxTime(op, opts, options.Count().SetMaxTime(op.maxTime)), opt)...)
 		return
-	}
+	})
 	return
 }
 
 func (c *collectionWrapper) EstimatedCount(ctx context.Context, opts ...*options.EstimatedDocumentCountOptions) (count int64, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "EstimatedCount")
-	defer span.End()
-
-	count, err = conn.Database(c.database).Collection(c.collection).EstimatedDocumentCount(ctx, opts...)
-	if err != nil {
-		return
-	}
-	return
-}
-
-func (c *collectionWrapper) deleteOne(ctx context.Context, filter interface{},
-	opts ...*options.DeleteOptions) (has bool, err error) {
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "deleteOne")
-	defer span.End()
-
-	result, err := conn.Database(c.database).Collection(c.collection).DeleteOne(ctx, filter, opts...)
-	if err != nil {
+	op := c.newOperation("EstimatedCount", "count")
+	op.Options, op.Result = opts, &count
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		count, err = c.Collection().EstimatedDocumentCount(ctx,
+			prependMaxTime(op, opts, options.EstimatedDocumentCount().SetMaxTime(op.maxTime))...)
 		return
-	}
-	has = result.DeletedCount > 0
+	})
 	return
 }
 
 func (c *collectionWrapper) DeleteOne(ctx context.Context, filter interface{},
 	opts ...*options.DeleteOptions) (has bool, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	return c.deleteOne(ctx, filter, opts...)
+	op := c.newOperation("DeleteOne", "delete")
+	op.Filter, op.Options = filter, opts
+	err = c.invoke(ctx, op, c.deleteOneInvoker(opts))
+	has = op.DocumentsAffected > 0
+	return
 }
 
 func (c *collectionWrapper) DeleteID(ctx context.Context, ID interface{},
 	opts ...*options.DeleteOptions) (has bool, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
+	op := c.newOperation("DeleteID", "delete")
+	op.Filter, op.Options = bson.M{"_id": ID}, opts
+	err = c.invoke(ctx, op, c.deleteOneInvoker(opts))
+	has = op.DocumentsAffected > 0
+	return
+}
 
-	filter := bson.M{"_id": ID}
-	return c.deleteOne(ctx, filter, opts...)
+func (c *collectionWrapper) deleteOneInvoker(opts []*options.DeleteOptions) Invoker {
+	return func(ctx context.Context, op *Operation) (err error) {
+		result, err := c.Collection().DeleteOne(ctx, op.Filter, opts...)
+		if err != nil {
+			return
+		}
+		op.DocumentsAffected = result.DeletedCount
+		return
+	}
 }
 
 func (c *collectionWrapper) DeleteMany(ctx context.Context, filter interface{},
 	opts ...*options.DeleteOptions) (deletedCnt int64, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "DeleteMany")
-	defer span.End()
-
-	result, err := conn.Database(c.database).Collection(c.collection).DeleteMany(ctx, filter, opts...)
-	if err != nil {
+	op := c.newOperation("DeleteMany", "delete")
+	op.Filter, op.Options, op.Result = filter, opts, &deletedCnt
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		result, err := c.Collection().DeleteMany(ctx, op.Filter, opts...)
+		if err != nil {
+			return
+		}
+		deletedCnt = result.DeletedCount
+		op.DocumentsAffected = deletedCnt
 		return
-	}
-	deletedCnt = result.DeletedCount
+	})
 	return
 }
 
 func (c *collectionWrapper) Distinct(ctx context.Context, filedName string, filter interface{},
 	opts ...*options.DistinctOptions) (result []interface{}, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "Distinct")
-	defer span.End()
-
-	result, err = conn.Database(c.database).Collection(c.collection).Distinct(ctx, filedName, filter, opts...)
-	if err != nil {
+	op := c.newOperation("Distinct", "distinct")
+	op.Field, op.Filter, op.Options, op.Result = filedName, filter, opts, &result
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		result, err = c.Collection().Distinct(ctx, op.Field, op.Filter,
+			prependMaxTime(op, opts, options.Distinct().SetMaxTime(op.maxTime))...)
+		if err != nil {
+			return
+		}
+		op.DocumentsReturned = int64(len(result))
 		return
-	}
+	})
 	return
 }
 
 func (c *collectionWrapper) Aggregate(ctx context.Context, pipeline, result interface{},
 	opts ...*options.AggregateOptions) (err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "Aggregate")
-	defer span.End()
-
-	cursor, err := conn.Database(c.database).Collection(c.collection).Aggregate(ctx, pipeline, opts...)
-	if err != nil {
-		return
-	}
-	err = c.client.ScanCursor(ctx, cursor, result)
-	if err != nil {
+	op := c.newOperation("Aggregate", "aggregate")
+	op.Pipeline, op.Options, op.Result = pipeline, opts, result
+	return c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		cursor, err := c.Collection().Aggregate(ctx, op.Pipeline,
+			prependMaxTime(op, opts, options.Aggregate().SetMaxTime(op.maxTime))...)
+		if err != nil {
+			return
+		}
+		err = c.client.ScanCursor(ctx, cursor, op.Result)
+		if err != nil {
+			return
+		}
+		op.DocumentsReturned = sliceLen(op.Result)
 		return
-	}
-	return
+	})
 }
 
 func (c *collectionWrapper) UseSession(ctx context.Context, fn func(mongo.SessionContext) error,
 	opts ...*options.SessionOptions) (err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "UseSession")
-	defer span.End()
-
-	err = conn.UseSessionWithOptions(ctx, options.MergeSessionOptions(opts...), fn)
-	if err != nil {
-		return
-	}
-	return
+	op := c.newOperation("UseSession", "session")
+	op.Options = opts
+	return c.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+		return c.client.Client().UseSessionWithOptions(ctx, options.MergeSessionOptions(opts...), fn)
+	})
 }
 
 func (c *collectionWrapper) BulkWrite(ctx context.Context, models []mongo.WriteModel,
 	opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error) {
 
-	metric := c.startMetric()
-	defer func() {
-		c.endMetric(metric, err)
-	}()
-
-	conn := c.client.Client()
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx, cancel := context.WithTimeout(ctx, c.client.Timeout())
-	defer cancel()
-	ctx, span := traceMongo(ctx, c.database, c.collection, "BulkWrite")
-	defer span.End()
-
-	oriResult, err := conn.Database(c.database).Collection(c.collection).BulkWrite(ctx, models, options.MergeBulkWriteOptions(opts...))
-	if err != nil {
-		return
-	}
-
-	result = &mongo.BulkWriteResult{
-		InsertedCount: oriResult.InsertedCount,
-		MatchedCount:  oriResult.MatchedCount,
-		ModifiedCount: oriResult.ModifiedCount,
-		DeletedCount:  oriResult.DeletedCount,
-		UpsertedCount: oriResult.UpsertedCount,
-		UpsertedIDs:   oriResult.UpsertedIDs,
-	}
-	return
-}
-
-type commandMetricInfo struct {
-	st         time.Time
-	callerName string
-}
-
-func (c *collectionWrapper) startMetric() commandMetricInfo {
-	pc := make([]uintptr, 2)
-	runtime.Callers(2, pc)
-	caller := runtime.FuncForPC(pc[0])
-	if caller == nil {
-		return commandMetricInfo{}
-	}
-
-	callerName := caller.Name()
-	if idx := strings.LastIndex(callerName, "."); idx > 0 {
-		callerName = callerName[idx+1:]
-	}
-	return commandMetricInfo{
-		st:         time.Now(),
-		callerName: callerName,
-	}
-}
+	op := c.newOperation("BulkWrite", "bulkWrite")
+	op.Models, op.Options, op.Result = models, opts, &result
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		oriResult, err := c.Collection().BulkWrite(ctx, op.Models, options.MergeBulkWriteOptions(opts...))
+		if err != nil {
+			return
+		}
 
-func (c *collectionWrapper) endMetric(info commandMetricInfo, err error) {
-	if info == (commandMetricInfo{}) {
+		result = &mongo.BulkWriteResult{
+			InsertedCount: oriResult.InsertedCount,
+			MatchedCount:  oriResult.MatchedCount,
+			ModifiedCount: oriResult.ModifiedCount,
+			DeletedCount:  oriResult.DeletedCount,
+			UpsertedCount: oriResult.UpsertedCount,
+			UpsertedIDs:   oriResult.UpsertedIDs,
+		}
+		op.DocumentsAffected = result.InsertedCount + result.ModifiedCount + result.DeletedCount + result.UpsertedCount
 		return
-	}
-	labels := prometheus.Labels{
-		"target":     c.client.metricTarget,
-		"command":    info.callerName,
-		"db":         c.client.convertMetricsLabel(c.database),
-		"collection": c.client.convertMetricsLabel(c.collection),
-	}
-	if err != nil {
-		metricError.With(labels).Add(1)
-	}
-	metricLatency.With(labels).Observe(float64(time.Since(info.st).Milliseconds()))
-}
-
-func traceMongo(parent context.Context, db, collection, command string) (ctx context.Context, span trace.Span) {
-	tr := otel.GetTracerProvider().Tracer("mongo")
-	ctx, span = tr.Start(parent, "db|"+db+"."+collection)
-	span.SetAttributes(attribute.String("command", command))
+	})
 	return
 }
@@ -2,8 +2,12 @@ package gomongodb
 
 import (
 	"context"
+	"reflect"
 
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -34,6 +38,18 @@ type CollectionWrapperGeneric[T any] interface {
 
 	// InsertMany
 	InsertMany(ctx context.Context, document []T, opts ...*options.InsertManyOptions) (insertedIDs []interface{}, err error)
+
+	// FindWithTotal 一次聚合返回一页数据及满足filter的总数，WithEstimatedTotal且filter为空时改为Find及EstimatedDocumentCount
+	FindWithTotal(ctx context.Context, filter interface{}, sort []string, skip, limit int64, opts ...*options.AggregateOptions) (result []T, total int64, err error)
+
+	// ForEach 遍历满足filter的文档，逐个回调fn，遍历期间保留metrics及trace，返回前关闭游标
+	ForEach(ctx context.Context, filter interface{}, sort []string, fn func(ctx context.Context, doc T) error, opts ...*options.FindOptions) error
+
+	// Stream 同ForEach，每batchSize个文档回调一次fn
+	Stream(ctx context.Context, filter interface{}, sort []string, batchSize int, fn func(ctx context.Context, batch []T) error, opts ...*options.FindOptions) error
+
+	// Paginate 基于排序字段的值分页，token为空时返回第一页，之后传入Page的NextToken、PrevToken翻页
+	Paginate(ctx context.Context, filter interface{}, sort []string, limit int64, token string, opts ...*options.FindOptions) (page *Page[T], err error)
 }
 
 type collectionWrapperGeneric[T any] struct {
@@ -42,13 +58,13 @@ type collectionWrapperGeneric[T any] struct {
 
 func (c *collectionWrapperGeneric[T]) Find(ctx context.Context, filter interface{},
 	sort []string, skip, limit int64, opts ...*options.FindOptions) (result []T, err error) {
-	err = c.collectionWrapper.Find(ctx, filter, &result, sort, skip, limit, opts...)
+	err = c.collectionWrapper.Find(ctx, typedFilter[T](filter), &result, sort, skip, limit, opts...)
 	return
 }
 
 func (c *collectionWrapperGeneric[T]) FindOne(ctx context.Context, filter interface{},
 	sort []string, skip int64, opts ...*options.FindOneOptions) (result T, has bool, err error) {
-	has, err = c.collectionWrapper.FindOne(ctx, filter, &result, sort, skip, opts...)
+	has, err = c.collectionWrapper.FindOne(ctx, typedFilter[T](filter), &result, sort, skip, opts...)
 	return
 }
 
@@ -60,19 +76,19 @@ func (c *collectionWrapperGeneric[T]) FindID(ctx context.Context, ID interface{}
 
 func (c *collectionWrapperGeneric[T]) FindOneAndUpdate(ctx context.Context, filter, update interface{},
 	sort []string, upsert, returnNew bool, opts ...*options.FindOneAndUpdateOptions) (result T, has bool, err error) {
-	has, err = c.collectionWrapper.FindOneAndUpdate(ctx, filter, update, &result, sort, upsert, returnNew, opts...)
+	has, err = c.collectionWrapper.FindOneAndUpdate(ctx, typedFilter[T](filter), update, &result, sort, upsert, returnNew, opts...)
 	return
 }
 
 func (c *collectionWrapperGeneric[T]) FindOneAndReplace(ctx context.Context, filter, replacement interface{},
 	sort []string, upsert, returnNew bool, opts ...*options.FindOneAndReplaceOptions) (result T, has bool, err error) {
-	has, err = c.collectionWrapper.FindOneAndReplace(ctx, filter, replacement, &result, sort, upsert, returnNew, opts...)
+	has, err = c.collectionWrapper.FindOneAndReplace(ctx, typedFilter[T](filter), replacement, &result, sort, upsert, returnNew, opts...)
 	return
 }
 
 func (c *collectionWrapperGeneric[T]) FindOneAndDelete(ctx context.Context, filter interface{},
 	sort []string, opts ...*options.FindOneAndDeleteOptions) (result T, has bool, err error) {
-	has, err = c.collectionWrapper.FindOneAndDelete(ctx, filter, &result, sort, opts...)
+	has, err = c.collectionWrapper.FindOneAndDelete(ctx, typedFilter[T](filter), &result, sort, opts...)
 	return
 }
 
@@ -85,3 +101,222 @@ func (c *collectionWrapperGeneric[T]) InsertMany(ctx context.Context, document [
 	opts ...*options.InsertManyOptions) (insertedIDs []interface{}, err error) {
 	return c.collectionWrapper.InsertMany(ctx, lo.ToAnySlice(document), opts...)
 }
+
+// FindAs 查询T的集合，只返回P中的字段并解析为P，projection由P的bson tag生成，见builder.Projection。
+// P中的字段需在T中存在，否则不发出请求，返回builder.ErrUnknownField。
+// wrapper需实现ProjectionFinder，否则返回ErrProjectionUnsupported。
+//
+//	type UserBrief struct {
+//		Name  string `bson:"name"`
+//		Likes int64  `bson:"likes"`
+//	}
+//	briefs, err := gomongodb.FindAs[User, UserBrief](ctx, wrapper, filter, []string{"-likes"}, 0, 20)
+func FindAs[T, P any](ctx context.Context, wrapper CollectionWrapperGeneric[T], filter interface{},
+	sort []string, skip, limit int64, opts ...*options.FindOptions) (result []P, err error) {
+
+	if err = builder.ValidateProjection[T, P](); err != nil {
+		return nil, projectionError(wrapper, "FindProjection", err)
+	}
+	finder, err := projectionFinder(wrapper)
+	if err != nil {
+		return nil, projectionError(wrapper, "FindProjection", err)
+	}
+	err = finder.FindProjection(ctx, typedFilter[T](filter), builder.Projection[P](), &result, sort, skip, limit, opts...)
+	return
+}
+
+// FindOneAs 同FindAs，只返回一个文档
+func FindOneAs[T, P any](ctx context.Context, wrapper CollectionWrapperGeneric[T], filter interface{},
+	sort []string, skip int64, opts ...*options.FindOneOptions) (result P, has bool, err error) {
+
+	if err = builder.ValidateProjection[T, P](); err != nil {
+		err = projectionError(wrapper, "FindOneProjection", err)
+		return
+	}
+	finder, err := projectionFinder(wrapper)
+	if err != nil {
+		err = projectionError(wrapper, "FindOneProjection", err)
+		return
+	}
+	has, err = finder.FindOneProjection(ctx, typedFilter[T](filter), builder.Projection[P](), &result, sort, skip, opts...)
+	return
+}
+
+// ErrFilterType filter为其他文档类型构造的builder.Filter
+var ErrFilterType = errors.New("gomongodb: filter is built for another document type")
+
+// typedFilter builder.Filter的文档类型与T不同时（指针与其指向的类型视为相同），
+// 返回在statementInterceptor中生成失败的filter，不发出请求
+func typedFilter[T any](filter interface{}) interface{} {
+	typed, ok := filter.(interface{ DocumentType() reflect.Type })
+	if !ok {
+		return filter
+	}
+	got, want := typed.DocumentType(), reflect.TypeOf((*T)(nil)).Elem()
+	for got.Kind() == reflect.Pointer {
+		got = got.Elem()
+	}
+	for want.Kind() == reflect.Pointer {
+		want = want.Elem()
+	}
+	if got != want {
+		return statementFunc(func() (bson.D, error) {
+			return nil, errors.Wrapf(ErrFilterType, "%v, want %v", got, want)
+		})
+	}
+	return filter
+}
+
+// ErrProjectionUnsupported wrapper未实现ProjectionFinder，FindAs、FindOneAs无法执行
+var ErrProjectionUnsupported = errors.New("gomongodb: wrapper does not implement ProjectionFinder")
+
+// projectionError FindAs、FindOneAs未执行查询时的错误，同样包装为*OperationError
+func projectionError(wrapper CollectionWrapperBase, name string, err error) error {
+	if c, ok := wrapper.(interface{ operationError(string, error) error }); ok {
+		return c.operationError(name, err)
+	}
+	// 外部实现的wrapper无法得到db、collection
+	return wrapOperationError(&Operation{Name: name}, err)
+}
+
+func projectionFinder(wrapper interface{}) (ProjectionFinder, error) {
+	finder, ok := wrapper.(ProjectionFinder)
+	if !ok {
+		return nil, ErrProjectionUnsupported
+	}
+	return finder, nil
+}
+
+// FindWithTotal 返回一页数据及满足filter的总数，通过一次$facet聚合完成，两者基于同一时刻的数据。
+// 执行的pipeline由Filter、Sort、Skip、Limit生成，interceptor修改这些字段会影响执行的语句。
+// 聚合的结果为一个文档，受16MB的限制，limit不宜过大。
+// 通过WithEstimatedTotal开启后，filter为空时改为先Find再EstimatedDocumentCount，opts中适用的选项同样生效，
+// 仍记录为一次FindWithTotal，数据与总数不保证基于同一时刻，总数为近似值。
+func (c *collectionWrapperGeneric[T]) FindWithTotal(ctx context.Context, filter interface{}, sort []string,
+	skip, limit int64, opts ...*options.AggregateOptions) (result []T, total int64, err error) {
+
+	op := c.newOperation("FindWithTotal", "aggregate")
+	op.Filter, op.Sort, op.Skip, op.Limit, op.Options, op.Result = typedFilter[T](filter), sort, skip, limit, opts, &result
+	op.Pipeline = c.facetPipeline(op)
+	err = c.invoke(ctx, op, func(ctx context.Context, op *Operation) (err error) {
+		if c.wrapperOpts.estimatedTotal && isEmptyFilter(op.Filter) {
+			return c.findWithEstimatedTotal(ctx, op, opts, &result, &total)
+		}
+		op.Pipeline = c.facetPipeline(op)
+		cursor, err := c.Collection().Aggregate(ctx, op.Pipeline,
+			prependMaxTime(op, opts, options.Aggregate().SetMaxTime(op.maxTime))...)
+		if err != nil {
+			return
+		}
+		var facets []struct {
+			Items []T `bson:"items"`
+			Total []struct {
+				N int64 `bson:"n"`
+			} `bson:"total"`
+		}
+		if err = cursor.All(ctx, &facets); err != nil {
+			return
+		}
+		if len(facets) > 0 {
+			result = facets[0].Items
+			if len(facets[0].Total) > 0 {
+				total = facets[0].Total[0].N
+			}
+		}
+		op.DocumentsReturned = int64(len(result))
+		return
+	})
+	return
+}
+
+// findWithEstimatedTotal filter为空时的FindWithTotal，先Find再EstimatedDocumentCount
+func (c *collectionWrapperGeneric[T]) findWithEstimatedTotal(ctx context.Context, op *Operation,
+	opts []*options.AggregateOptions, result *[]T, total *int64) error {
+
+	findOpt, countOpt := c.estimatedTotalOptions(op, opts)
+	cursor, err := c.Collection().Find(ctx, bson.D{}, findOpt)
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, result); err != nil {
+		return err
+	}
+	op.DocumentsReturned = int64(len(*result))
+	*total, err = c.Collection().EstimatedDocumentCount(ctx, countOpt)
+	return err
+}
+
+// estimatedTotalOptions 将FindWithTotal的opts转换为Find、EstimatedDocumentCount适用的options，后设置的优先
+func (c *collectionWrapperGeneric[T]) estimatedTotalOptions(op *Operation, opts []*options.AggregateOptions) (
+	*options.FindOptions, *options.EstimatedDocumentCountOptions) {
+
+	findOpt := options.Find().SetSkip(op.Skip).SetLimit(op.Limit)
+	if len(op.Sort) > 0 {
+		findOpt.SetSort(c.GenSortBson(op.Sort))
+	}
+	countOpt := options.EstimatedDocumentCount()
+	for _, opt := range prependMaxTime(op, opts, options.Aggregate().SetMaxTime(op.maxTime)) {
+		if opt == nil {
+			continue
+		}
+		if opt.AllowDiskUse != nil {
+			findOpt.AllowDiskUse = opt.AllowDiskUse
+		}
+		if opt.BatchSize != nil {
+			findOpt.BatchSize = opt.BatchSize
+		}
+		if opt.Collation != nil {
+			findOpt.Collation = opt.Collation
+		}
+		if opt.Hint != nil {
+			findOpt.Hint = opt.Hint
+		}
+		if opt.Let != nil {
+			findOpt.Let = opt.Let
+		}
+		if opt.MaxTime != nil {
+			findOpt.MaxTime, countOpt.MaxTime = opt.MaxTime, opt.MaxTime
+		}
+		if opt.Comment != nil {
+			findOpt.Comment, countOpt.Comment = opt.Comment, *opt.Comment
+		}
+	}
+	return findOpt, countOpt
+}
+
+// facetPipeline [{$match}, {$facet: {items: [{$sort}, {$skip}, {$limit}], total: [{$count}]}}]
+func (c *collectionWrapperGeneric[T]) facetPipeline(op *Operation) bson.A {
+	match := op.Filter
+	if match == nil {
+		match = bson.D{}
+	}
+	items := bson.A{}
+	if len(op.Sort) > 0 {
+		items = append(items, bson.D{{Key: "$sort", Value: c.GenSortBson(op.Sort)}})
+	}
+	// $facet的子pipeline不能为空
+	items = append(items, bson.D{{Key: "$skip", Value: op.Skip}})
+	if op.Limit > 0 {
+		items = append(items, bson.D{{Key: "$limit", Value: op.Limit}})
+	}
+	return bson.A{
+		bson.D{{Key: "$match", Value: match}},
+		bson.D{{Key: "$facet", Value: bson.D{
+			{Key: "items", Value: items},
+			{Key: "total", Value: bson.A{bson.D{{Key: "$count", Value: "n"}}}},
+		}}},
+	}
+}
+
+// isEmptyFilter filter为nil或空文档
+func isEmptyFilter(filter interface{}) bool {
+	if filter == nil {
+		return true
+	}
+	_, data, err := bson.MarshalValue(filter)
+	if err != nil {
+		return false
+	}
+	elems, err := bson.Raw(data).Elements()
+	return err == nil && len(elems) == 0
+}
@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -1278,3 +1280,289 @@ func Test_collectionWrapperGeneric_Ptr_InsertMany(t *testing.T) {
 		})
 	}
 }
+
+func Test_FindAs(t *testing.T) {
+	type likesOnly struct {
+		Likes int64 `bson:"likes"`
+	}
+	client := newClientForTest(&recordSink{})
+	var ops []*Operation
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		ops = append(ops, op)
+		switch result := op.Result.(type) {
+		case *[]likesOnly:
+			*result = []likesOnly{{Likes: 1}, {Likes: 2}}
+			op.DocumentsReturned = 2
+		case *likesOnly:
+			*result = likesOnly{Likes: 3}
+			op.DocumentsReturned = 1
+		}
+		return nil
+	})
+	wrapper := NewCollectionWrapper[testDataIDSt](client, "db", "col")
+
+	result, err := FindAs[testDataIDSt, likesOnly](context.Background(), wrapper, bson.M{}, []string{"likes"}, 0, 10)
+	if err != nil || !reflect.DeepEqual(result, []likesOnly{{Likes: 1}, {Likes: 2}}) {
+		t.Errorf("FindAs() = %v, %v", result, err)
+	}
+	one, has, err := FindOneAs[testDataIDSt, likesOnly](context.Background(), wrapper, bson.M{}, nil, 0)
+	if err != nil || !has || one.Likes != 3 {
+		t.Errorf("FindOneAs() = %v, %v, %v", one, has, err)
+	}
+	want := bson.D{{Key: "likes", Value: 1}, {Key: "_id", Value: 0}}
+	for _, op := range ops {
+		if !reflect.DeepEqual(op.Projection, want) {
+			t.Errorf("%s Operation.Projection = %v, want %v", op.Name, op.Projection, want)
+		}
+	}
+	if len(ops) != 2 || ops[0].Name != "FindProjection" || ops[1].Name != "FindOneProjection" {
+		t.Errorf("operations = %v", ops)
+	}
+
+	type typo struct {
+		Lieks int64 `bson:"lieks"`
+	}
+	var oe *OperationError
+	if _, err := FindAs[testDataIDSt, typo](context.Background(), wrapper, bson.M{}, nil, 0, 0); !errors.Is(err, builder.ErrUnknownField) || !errors.As(err, &oe) || oe.Operation != "FindProjection" {
+		t.Errorf("FindAs() error = %v, want builder.ErrUnknownField", err)
+	}
+	if len(ops) != 2 {
+		t.Errorf("FindAs() with unknown field should not be executed")
+	}
+
+	// 外部实现的wrapper未实现ProjectionFinder
+	external := struct {
+		CollectionWrapperGeneric[testDataIDSt]
+	}{wrapper}
+	if _, err := FindAs[testDataIDSt, likesOnly](context.Background(), external, bson.M{}, nil, 0, 0); !errors.Is(err, ErrProjectionUnsupported) {
+		t.Errorf("FindAs() error = %v, want ErrProjectionUnsupported", err)
+	}
+}
+
+func Test_collectionWrapperGeneric_Paginate(t *testing.T) {
+	genGenericWrapper(t)
+	resetTestDataGeneric(t, testDataGroup1)
+	ctx := context.Background()
+
+	var likes []int64
+	var page *Page[testDataIDSt]
+	var err error
+	for token := ""; ; token = page.NextToken {
+		page, err = colWrapperGenericForTest.Paginate(ctx, bson.M{}, []string{"-likes"}, 2, token)
+		if err != nil {
+			t.Fatalf("Paginate() error = %v", err)
+		}
+		for _, item := range page.Items {
+			likes = append(likes, item.Likes)
+		}
+		if page.NextToken == "" {
+			break
+		}
+	}
+	if want := []int64{5, 4, 3, 2, 1}; !reflect.DeepEqual(likes, want) {
+		t.Errorf("Paginate() likes = %v, want %v", likes, want)
+	}
+
+	// 最后一页向前翻
+	page, err = colWrapperGenericForTest.Paginate(ctx, bson.M{}, []string{"-likes"}, 2, page.PrevToken)
+	if err != nil || len(page.Items) != 2 || page.Items[0].Likes != 3 || page.Items[1].Likes != 2 {
+		t.Errorf("Paginate() backward = %+v, %v", page, err)
+	}
+}
+
+func Test_FindWithTotal(t *testing.T) {
+	sink := &recordSink{}
+	client := newClientForTest(sink)
+	var ops []*Operation
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		ops = append(ops, op)
+		return nil
+	})
+	ctx := context.Background()
+
+	wrapper := NewCollectionWrapper[testDataIDSt](client, "db", "col")
+	if _, _, err := wrapper.FindWithTotal(ctx, builder.NewFilter[testDataIDSt]().Gt("likes", 1), []string{"-likes"}, 10, 5); err != nil {
+		t.Fatalf("FindWithTotal() error = %v", err)
+	}
+	want := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "likes", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		bson.D{{Key: "$facet", Value: bson.D{
+			{Key: "items", Value: bson.A{
+				bson.D{{Key: "$sort", Value: bson.D{{Key: "likes", Value: -1}}}},
+				bson.D{{Key: "$skip", Value: int64(10)}},
+				bson.D{{Key: "$limit", Value: int64(5)}},
+			}},
+			{Key: "total", Value: bson.A{bson.D{{Key: "$count", Value: "n"}}}},
+		}}},
+	}
+	if len(ops) != 1 || ops[0].Name != "FindWithTotal" || ops[0].Command != "aggregate" || !reflect.DeepEqual(ops[0].Pipeline, want) {
+		t.Errorf("Operation = %+v", ops)
+	}
+	if len(sink.records) != 1 || sink.records[0].Command != "FindWithTotal" {
+		t.Errorf("metrics records = %+v", sink.records)
+	}
+
+	// filter生成失败时同样经过metrics，不执行后续的interceptor
+	ops, sink.records = nil, nil
+	_, _, err := wrapper.FindWithTotal(ctx, builder.NewFilter[testDataIDSt]().Gt("unknown", 1), nil, 0, 5)
+	var oe *OperationError
+	if !errors.As(err, &oe) || oe.Operation != "FindWithTotal" {
+		t.Errorf("FindWithTotal() error = %v, want *OperationError", err)
+	}
+	if len(ops) != 0 || len(sink.records) != 1 || sink.records[0].Command != "FindWithTotal" {
+		t.Errorf("operations = %+v, metrics records = %+v", ops, sink.records)
+	}
+
+	// filter为空时使用EstimatedCount，仍记录为一次FindWithTotal
+	ops, sink.records = nil, nil
+	estimated := NewCollectionWrapper[testDataIDSt](client, "db", "col", WithEstimatedTotal())
+	if _, _, err := estimated.FindWithTotal(ctx, builder.NewFilter[testDataIDSt](), nil, 0, 5); err != nil {
+		t.Fatalf("FindWithTotal() error = %v", err)
+	}
+	if len(ops) != 1 || ops[0].Name != "FindWithTotal" || len(sink.records) != 1 {
+		t.Errorf("operations = %+v, metrics records = %+v", ops, sink.records)
+	}
+}
+
+func Test_typedFilter(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	var ops []*Operation
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		ops = append(ops, op)
+		return nil
+	})
+	wrapper := NewCollectionWrapper[testDataIDSt](client, "db", "col")
+	ctx := context.Background()
+
+	for _, filter := range []interface{}{nil, bson.M{"likes": 1}, builder.NewFilter[testDataIDSt]().Eq("likes", 1), builder.NewFilter[*testDataIDSt]()} {
+		if _, err := wrapper.Find(ctx, filter, nil, 0, 1); err != nil {
+			t.Errorf("Find(%v) error = %v", filter, err)
+		}
+	}
+	if len(ops) != 4 {
+		t.Errorf("operations = %d, want 4", len(ops))
+	}
+
+	// 其他文档类型的Filter在生成时失败，不执行interceptor
+	ops = nil
+	other := builder.NewFilter[pageDoc]().Eq("likes", 1)
+	if _, err := wrapper.Find(ctx, other, nil, 0, 1); !errors.Is(err, ErrFilterType) {
+		t.Errorf("Find() error = %v, want ErrFilterType", err)
+	}
+	if _, _, err := wrapper.FindOne(ctx, other, nil, 0); !errors.Is(err, ErrFilterType) {
+		t.Errorf("FindOne() error = %v, want ErrFilterType", err)
+	}
+	if _, err := wrapper.Paginate(ctx, other, nil, 1, ""); !errors.Is(err, ErrFilterType) {
+		t.Errorf("Paginate() error = %v, want ErrFilterType", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("operations = %+v, want none", ops)
+	}
+}
+
+func Test_estimatedTotalOptions(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	c := NewCollectionWrapper[testDataIDSt](client, "db", "col").(*collectionWrapperGeneric[testDataIDSt])
+	op := c.newOperation("FindWithTotal", "aggregate")
+	op.Sort, op.Skip, op.Limit = []string{"-likes"}, 10, 5
+	collation := &options.Collation{Locale: "zh"}
+	findOpt, countOpt := c.estimatedTotalOptions(op, []*options.AggregateOptions{
+		options.Aggregate().SetCollation(collation).SetComment("first").SetMaxTime(time.Second),
+		nil,
+		options.Aggregate().SetHint("likes_1").SetComment("second").SetAllowDiskUse(true),
+	})
+	if *findOpt.Skip != 10 || *findOpt.Limit != 5 || !reflect.DeepEqual(findOpt.Sort, bson.D{{Key: "likes", Value: -1}}) {
+		t.Errorf("find options = %+v", findOpt)
+	}
+	if findOpt.Collation != collation || findOpt.Hint != "likes_1" || *findOpt.Comment != "second" || !*findOpt.AllowDiskUse || *findOpt.MaxTime != time.Second {
+		t.Errorf("find options = %+v", findOpt)
+	}
+	if countOpt.Comment != "second" || *countOpt.MaxTime != time.Second {
+		t.Errorf("count options = %+v", countOpt)
+	}
+}
+
+func Test_isEmptyFilter(t *testing.T) {
+	for _, filter := range []interface{}{nil, bson.M{}, bson.D{}, map[string]interface{}{}, struct{}{}} {
+		if !isEmptyFilter(filter) {
+			t.Errorf("isEmptyFilter(%v) = false", filter)
+		}
+	}
+	for _, filter := range []interface{}{bson.M{"a": 1}, bson.D{{Key: "a", Value: 1}}} {
+		if isEmptyFilter(filter) {
+			t.Errorf("isEmptyFilter(%v) = true", filter)
+		}
+	}
+}
+
+func Test_collectionWrapperGeneric_FindWithTotal(t *testing.T) {
+	genGenericWrapper(t)
+	resetTestDataGeneric(t, testDataGroup1)
+
+	result, total, err := colWrapperGenericForTest.FindWithTotal(context.Background(), bson.M{"likes": bson.M{"$gt": 1}}, []string{"likes"}, 1, 2)
+	if err != nil {
+		t.Fatalf("FindWithTotal() error = %v", err)
+	}
+	if total != 4 || len(result) != 2 || result[0].Likes != 3 || result[1].Likes != 4 {
+		t.Errorf("FindWithTotal() = %+v, %v", result, total)
+	}
+
+	result, total, err = colWrapperGenericForTest.FindWithTotal(context.Background(), bson.M{"likes": 100}, nil, 0, 2)
+	if err != nil || total != 0 || len(result) != 0 {
+		t.Errorf("FindWithTotal() = %+v, %v, %v", result, total, err)
+	}
+}
+
+func Test_collectionWrapperGeneric_Stream(t *testing.T) {
+	genGenericWrapper(t)
+	resetTestDataGeneric(t, testDataGroup1)
+	ctx := context.Background()
+
+	var batches [][]int64
+	err := colWrapperGenericForTest.Stream(ctx, bson.M{}, []string{"likes"}, 2, func(ctx context.Context, batch []testDataIDSt) error {
+		likes := make([]int64, len(batch))
+		for i, item := range batch {
+			likes[i] = item.Likes
+		}
+		batches = append(batches, likes)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if want := [][]int64{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("Stream() batches = %v, want %v", batches, want)
+	}
+
+	// 回调返回错误时停止遍历
+	stop := errors.New("stop")
+	var count int
+	err = colWrapperGenericForTest.ForEach(ctx, bson.M{}, []string{"-likes"}, func(ctx context.Context, doc testDataIDSt) error {
+		if count++; count == 3 {
+			return stop
+		}
+		return nil
+	}, options.Find().SetBatchSize(1))
+	if !errors.Is(err, stop) || count != 3 {
+		t.Errorf("ForEach() error = %v, count = %d", err, count)
+	}
+}
+
+func Test_collectionWrapperGeneric_BatchProcessor(t *testing.T) {
+	genGenericWrapper(t)
+	resetTestDataGeneric(t, testDataGroup1)
+	ctx := context.Background()
+
+	p := NewBatchProcessor[testDataIDSt](officialClient, dbColForTest, dbColForTest, BatchConfig{Name: "test-batch", BatchSize: 2})
+	if err := p.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	cp, err := p.Run(ctx, func(ctx context.Context, batch []testDataIDSt, dryRun bool) error {
+		count += len(batch)
+		return nil
+	})
+	if err != nil || count != len(testDataGroup1) || !cp.Done || cp.Processed != int64(len(testDataGroup1)) {
+		t.Errorf("Run() = %+v, %v, count = %d", cp, err, count)
+	}
+}
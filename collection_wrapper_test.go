@@ -349,6 +349,26 @@ func Test_collectionWrapperOfficial_Find(t *testing.T) {
 	}
 }
 
+func Test_collectionWrapperOfficial_FindProjection(t *testing.T) {
+	genDefaultWrapper(t)
+	resetTestData(t, testDataGroup1)
+
+	var result []testDataSt
+	err := colWrapperForTest.FindProjection(context.Background(), bson.M{}, bson.M{"likes": 1}, &result, []string{"likes"}, 1, 2)
+	if err != nil {
+		t.Fatalf("FindProjection() error = %v", err)
+	}
+	if want := []testDataSt{{Likes: 2}, {Likes: 3}}; !reflect.DeepEqual(result, want) {
+		t.Errorf("FindProjection() = %v, want %v", result, want)
+	}
+
+	var one testDataSt
+	has, err := colWrapperForTest.FindOneProjection(context.Background(), bson.M{"likes": 5}, bson.M{"score": 1}, &one, nil, 0)
+	if err != nil || !has || !reflect.DeepEqual(one, testDataSt{Score: 0.6}) {
+		t.Errorf("FindOneProjection() = %v, %v, %v", one, has, err)
+	}
+}
+
 func Test_collectionWrapperOfficial_FindOne(t *testing.T) {
 	genDefaultWrapper(t)
 	resetTestData(t, testDataGroup1)
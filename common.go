@@ -1,10 +1,13 @@
 package gomongodb
 
 import (
+	"net"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/huaiyann/gomongodb/builder"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -12,13 +15,34 @@ import (
 const (
 	DEFAULT_POOLSIZE       = 3
 	DEFAULT_SOCKET_TIMEOUT = 10 * time.Second
+
+	DEFAULT_WAIT_POOL_TIMEOUT = time.Second
+
+	DEFAULT_RETRY_INITIAL_BACKOFF = 50 * time.Millisecond
+	DEFAULT_RETRY_MAX_BACKOFF     = time.Second
+
+	// DEFAULT_CREDENTIAL_RETIRE_TIMEOUT 更换凭证后，旧连接上进行中的操作最多等待的时间。
+	// 旧连接上FindCursor返回的游标不受此限制，等待到读完、关闭或被服务端回收
+	DEFAULT_CREDENTIAL_RETIRE_TIMEOUT = time.Minute
+
+	// DEFAULT_CURSOR_IDLE_TIMEOUT 服务端回收空闲游标的时间，同cursorTimeoutMillis的默认值
+	DEFAULT_CURSOR_IDLE_TIMEOUT = 10 * time.Minute
+
+	DEFAULT_METRIC_NAMESPACE = "gomongodb"
 )
 
+// DEFAULT_METRIC_BUCKETS 耗时直方图默认的bucket，单位毫秒
+var DEFAULT_METRIC_BUCKETS = []float64{5, 15, 30, 50, 100, 300, 600, 1000, 2500, 5000, 10000}
+
 // updateSafeCheck尽量约束update语句，防止意外的字段覆盖
 func updateSafeCheck(update interface{}) error {
 	if update == nil {
 		return nil
 	}
+	if _, ok := update.(builder.SafeUpdate); ok {
+		// builder.Update生成时已校验
+		return nil
+	}
 	m, ok := update.(bson.M)
 	if !ok {
 		return errors.New("update语句只接受bson.M类型")
@@ -66,3 +90,39 @@ func updateSafeCheck(update interface{}) error {
 	}
 	return nil
 }
+
+// hostsFromURI 从mongodb连接串中提取host列表，去掉scheme、用户名密码、database及参数
+func hostsFromURI(uri string) string {
+	if idx := strings.Index(uri, "://"); idx >= 0 {
+		uri = uri[idx+3:]
+	}
+	// 与driver一致，先去掉path及query，其中可能包含@，如appName=a@b；密码中的/、?需转义
+	if idx := strings.IndexAny(uri, "/?"); idx >= 0 {
+		uri = uri[:idx]
+	}
+	// 未转义的密码中可能包含@，取最后一个
+	if idx := strings.LastIndex(uri, "@"); idx >= 0 {
+		uri = uri[idx+1:]
+	}
+	return uri
+}
+
+// serverAddressFromURI 取连接串中的第一个host作为trace的server.address，没有端口时port为0
+func serverAddressFromURI(uri string) (address string, port int) {
+	host, _, _ := strings.Cut(hostsFromURI(uri), ",")
+	address, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return host, 0
+	}
+	port, _ = strconv.Atoi(portStr)
+	return
+}
+
+// sliceLen 获取slice地址指向的slice长度，非slice地址时返回0
+func sliceLen(result interface{}) int64 {
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return 0
+	}
+	return int64(v.Elem().Len())
+}
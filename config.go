@@ -0,0 +1,307 @@
+package gomongodb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+var (
+	authMechanisms = []string{"SCRAM-SHA-1", "SCRAM-SHA-256", "MONGODB-X509", "MONGODB-AWS", "MONGODB-OIDC", "GSSAPI", "PLAIN"}
+	readConcerns   = []string{"local", "available", "majority", "linearizable", "snapshot"}
+	compressors    = []string{"zstd", "snappy", "zlib"}
+)
+
+// ConfigError Config.Validate发现的所有问题
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "gomongodb: invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate 校验Config，一次返回所有问题，返回的error为*ConfigError。
+// driverOpts为WithDriverOptions传入的options，其中设置了hosts（如通过ApplyURI）时不要求hostport。
+func (cfg Config) Validate(driverOpts ...*options.ClientOptions) error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Hostport == "" && !lo.ContainsBy(driverOpts, func(o *options.ClientOptions) bool { return o != nil && len(o.Hosts) > 0 }) {
+		addProblem("hostport is required")
+	}
+
+	if cfg.TLSKeyFile != "" && cfg.TLSCertFile == "" {
+		addProblem("tls_key_file requires tls_cert_file")
+	}
+	if _, err := cfg.tlsConfig(); err != nil {
+		addProblem("%v", err)
+	}
+
+	if cfg.AuthMechanism != "" && !lo.Contains(authMechanisms, cfg.AuthMechanism) {
+		addProblem("unknown auth_mechanism %q, should be one of %v", cfg.AuthMechanism, authMechanisms)
+	}
+	switch cfg.AuthMechanism {
+	case "MONGODB-X509":
+		if cfg.TLSCertFile == "" {
+			addProblem("auth_mechanism MONGODB-X509 requires tls_cert_file")
+		}
+	case "SCRAM-SHA-1", "SCRAM-SHA-256", "PLAIN":
+		if cfg.UserName == "" {
+			addProblem("auth_mechanism %s requires username", cfg.AuthMechanism)
+		}
+	}
+	if cfg.AuthSource != "" && cfg.UserName == "" && cfg.AuthMechanism == "" {
+		// 没有username及auth_mechanism时不会认证，auth_source不生效
+		addProblem("auth_source requires username or auth_mechanism")
+	}
+
+	if _, err := cfg.writeConcern(); err != nil {
+		addProblem("%v", err)
+	}
+	if cfg.ReadConcern != "" && !lo.Contains(readConcerns, cfg.ReadConcern) {
+		addProblem("unknown read_concern %q, should be one of %v", cfg.ReadConcern, readConcerns)
+	}
+	if _, err := cfg.readPreference(); err != nil {
+		addProblem("%v", err)
+	}
+
+	for _, c := range cfg.Compressors {
+		if !lo.Contains(compressors, c) {
+			addProblem("unknown compressor %q, should be one of %v", c, compressors)
+		}
+	}
+	if cfg.DirectConnection {
+		if strings.HasPrefix(cfg.Hostport, "mongodb+srv://") {
+			addProblem("direct_connection is not supported with mongodb+srv")
+		} else if strings.Contains(hostsFromURI(cfg.Hostport), ",") {
+			addProblem("direct_connection requires a single host")
+		}
+	}
+	if cfg.MinPoolSize < 0 {
+		addProblem("min_pool_size should not be negative")
+	} else {
+		// 与initClient一致，未设置poolsize时使用默认值
+		poolsize := cfg.Poolsize
+		if poolsize <= 0 {
+			poolsize = DEFAULT_POOLSIZE
+		}
+		if cfg.MinPoolSize > poolsize+2 {
+			addProblem("min_pool_size %d exceeds the driver max pool size %d", cfg.MinPoolSize, poolsize+2)
+		}
+	}
+	if cfg.MaxConnIdleTime < 0 {
+		addProblem("max_conn_idle_time should not be negative")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// driverOptions 根据Config生成driver options，需先通过Validate校验
+func (cfg Config) driverOptions(timeout time.Duration, poolsize int) (*options.ClientOptions, error) {
+	option := options.Client()
+	if cfg.Hostport != "" {
+		// 为空时hosts由WithDriverOptions设置
+		option.ApplyURI(cfg.Hostport)
+	}
+	option.SetConnectTimeout(timeout)
+	option.SetSocketTimeout(timeout)
+	option.SetMaxPoolSize(uint64(poolsize) + 2)
+	if cfg.MinPoolSize > 0 {
+		option.SetMinPoolSize(uint64(cfg.MinPoolSize))
+	}
+	maxConnIdleTime := time.Minute * 10
+	if cfg.MaxConnIdleTime > 0 {
+		maxConnIdleTime = time.Duration(cfg.MaxConnIdleTime) * time.Second
+	}
+	option.SetMaxConnIdleTime(maxConnIdleTime)
+
+	if cfg.UserName != "" || cfg.AuthMechanism != "" {
+		option.SetAuth(options.Credential{
+			AuthMechanism: cfg.AuthMechanism,
+			AuthSource:    cfg.AuthSource,
+			Username:      cfg.UserName,
+			Password:      cfg.Password,
+		})
+	}
+
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		option.SetTLSConfig(tlsConfig)
+	}
+
+	wc, err := cfg.writeConcern()
+	if err != nil {
+		return nil, err
+	}
+	if wc != nil {
+		option.SetWriteConcern(wc)
+	}
+	if cfg.ReadConcern != "" {
+		option.SetReadConcern(&readconcern.ReadConcern{Level: cfg.ReadConcern})
+	}
+	rp, err := cfg.readPreference()
+	if err != nil {
+		return nil, err
+	}
+	if rp != nil {
+		option.SetReadPreference(rp)
+	}
+
+	if len(cfg.Compressors) > 0 {
+		option.SetCompressors(cfg.Compressors)
+	}
+	if cfg.AppName != "" {
+		option.SetAppName(cfg.AppName)
+	}
+	if cfg.ReplicaSet != "" {
+		option.SetReplicaSet(cfg.ReplicaSet)
+	}
+	if cfg.DirectConnection {
+		option.SetDirect(true)
+	}
+	return option, nil
+}
+
+// tlsConfig 未开启TLS时返回nil
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	if !cfg.TLS && cfg.TLSCAFile == "" && cfg.TLSCertFile == "" && !cfg.TLSInsecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLSInsecureSkipVerify}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read tls_ca_file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in tls_ca_file %s", cfg.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.TLSCertFile != "" {
+		keyFile := cfg.TLSKeyFile
+		if keyFile == "" {
+			keyFile = cfg.TLSCertFile
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load tls_cert_file")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// writeConcern 未设置时返回nil
+func (cfg Config) writeConcern() (*writeconcern.WriteConcern, error) {
+	if cfg.WriteConcernW == "" && !cfg.WriteConcernJ && cfg.WriteConcernTimeout == 0 {
+		return nil, nil
+	}
+	if cfg.WriteConcernTimeout < 0 {
+		return nil, errors.New("write_concern_wtimeout should not be negative")
+	}
+	wc := &writeconcern.WriteConcern{WTimeout: time.Duration(cfg.WriteConcernTimeout) * time.Millisecond}
+	if cfg.WriteConcernW != "" {
+		if w, err := strconv.Atoi(cfg.WriteConcernW); err == nil {
+			if w < 0 {
+				return nil, errors.Errorf("write_concern_w %d should not be negative", w)
+			}
+			wc.W = w
+		} else {
+			// majority或tag名称
+			wc.W = cfg.WriteConcernW
+		}
+	}
+	if cfg.WriteConcernJ {
+		if wc.W == 0 {
+			return nil, errors.New("write_concern_j conflicts with write_concern_w 0")
+		}
+		j := true
+		wc.Journal = &j
+	}
+	return wc, nil
+}
+
+// readPreference 未设置时返回nil
+func (cfg Config) readPreference() (*readpref.ReadPref, error) {
+	mode := cfg.ReadPreference
+	if cfg.SecondaryPreferred {
+		if mode != "" && !strings.EqualFold(mode, readpref.SecondaryPreferredMode.String()) {
+			return nil, errors.Errorf("read_preference %s conflicts with enable_secondary_preferred", mode)
+		}
+		mode = readpref.SecondaryPreferredMode.String()
+	}
+	if mode == "" {
+		if cfg.ReadPreferenceTags != "" || cfg.MaxStaleness != 0 {
+			return nil, errors.New("read_preference_tags and max_staleness require read_preference")
+		}
+		return nil, nil
+	}
+
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, errors.Wrap(err, "read_preference")
+	}
+	var opts []readpref.Option
+	if cfg.ReadPreferenceTags != "" {
+		tagSets, err := parseTagSets(cfg.ReadPreferenceTags)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, readpref.WithTagSets(tagSets...))
+	}
+	if cfg.MaxStaleness != 0 {
+		if cfg.MaxStaleness < 90 {
+			return nil, errors.Errorf("max_staleness %d should be at least 90 seconds", cfg.MaxStaleness)
+		}
+		opts = append(opts, readpref.WithMaxStaleness(time.Duration(cfg.MaxStaleness)*time.Second))
+	}
+	rp, err := readpref.New(m, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "read_preference")
+	}
+	return rp, nil
+}
+
+// parseTagSets 解析dc:ny,rack:1;dc:sf格式的tag sets，空的tag set表示匹配任意节点
+func parseTagSets(s string) ([]tag.Set, error) {
+	var tagSets []tag.Set
+	for _, set := range strings.Split(s, ";") {
+		tagSet := tag.Set{}
+		for _, kv := range strings.Split(set, ",") {
+			kv = strings.TrimSpace(kv)
+			if kv == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(kv, ":")
+			if !ok || name == "" {
+				return nil, errors.Errorf("invalid read_preference_tags %q, should be like dc:ny,rack:1;dc:sf", s)
+			}
+			tagSet = append(tagSet, tag.Tag{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+		}
+		tagSets = append(tagSets, tagSet)
+	}
+	return tagSets, nil
+}
@@ -0,0 +1,141 @@
+package gomongodb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	valid := Config{
+		Hostport:            "mongodb://127.0.0.1:27017",
+		UserName:            "user",
+		AuthMechanism:       "SCRAM-SHA-256",
+		AuthSource:          "admin",
+		WriteConcernW:       "majority",
+		WriteConcernJ:       true,
+		WriteConcernTimeout: 500,
+		ReadConcern:         "majority",
+		ReadPreference:      "nearest",
+		ReadPreferenceTags:  "dc:ny,rack:1;dc:sf;",
+		MaxStaleness:        120,
+		Compressors:         []string{"zstd", "snappy"},
+		DirectConnection:    true,
+		MinPoolSize:         2,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	invalid := Config{
+		Hostport:           "mongodb://a:27017,b:27017",
+		TLSKeyFile:         "key.pem",
+		AuthMechanism:      "MONGODB-X509",
+		WriteConcernW:      "0",
+		WriteConcernJ:      true,
+		ReadConcern:        "strong",
+		SecondaryPreferred: true,
+		ReadPreference:     "primary",
+		Compressors:        []string{"gzip"},
+		DirectConnection:   true,
+		MinPoolSize:        -1,
+	}
+	err := invalid.Validate()
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("Validate() error = %v, want *ConfigError", err)
+	}
+	if len(ce.Problems) != 8 {
+		t.Errorf("Validate() got %d problems, want 8: %v", len(ce.Problems), err)
+	}
+
+	if err := (Config{}).Validate(); err == nil {
+		t.Error("Validate() should require hostport")
+	}
+	// hosts由driver options设置
+	if err := (Config{}).Validate(nil, options.Client().ApplyURI("mongodb://127.0.0.1:27017")); err != nil {
+		t.Errorf("Validate() with driver hosts error = %v", err)
+	}
+	if err := (Config{}).Validate(options.Client().SetAppName("app")); err == nil {
+		t.Error("Validate() should require hostport when driver options have no hosts")
+	}
+	// 未设置poolsize时按默认值校验min_pool_size
+	if err := (Config{Hostport: "mongodb://127.0.0.1:27017", MinPoolSize: 100}).Validate(); !errors.As(err, &ce) || len(ce.Problems) != 1 {
+		t.Errorf("Validate() with default poolsize and large min_pool_size error = %v", err)
+	}
+	if err := (Config{Hostport: "mongodb://127.0.0.1:27017", MinPoolSize: DEFAULT_POOLSIZE + 2}).Validate(); err != nil {
+		t.Errorf("Validate() with default poolsize error = %v", err)
+	}
+	// 不认证时auth_source不生效
+	if err := (Config{Hostport: "mongodb://127.0.0.1:27017", AuthSource: "admin"}).Validate(); !errors.As(err, &ce) || len(ce.Problems) != 1 {
+		t.Errorf("Validate() with only auth_source error = %v", err)
+	}
+	if err := (Config{Hostport: "mongodb://127.0.0.1:27017", AuthSource: "$external", AuthMechanism: "MONGODB-AWS"}).Validate(); err != nil {
+		t.Errorf("Validate() with auth_source and auth_mechanism error = %v", err)
+	}
+}
+
+func TestConfig_readPreference(t *testing.T) {
+	rp, err := Config{SecondaryPreferred: true}.readPreference()
+	if err != nil || rp.Mode() != readpref.SecondaryPreferredMode {
+		t.Fatalf("readPreference() = %v, %v", rp, err)
+	}
+
+	rp, err = Config{ReadPreference: "secondary", ReadPreferenceTags: "dc:ny,rack:1;", MaxStaleness: 90}.readPreference()
+	if err != nil {
+		t.Fatalf("readPreference() error = %v", err)
+	}
+	if sets := rp.TagSets(); len(sets) != 2 || !sets[0].Contains("rack", "1") || len(sets[1]) != 0 {
+		t.Errorf("TagSets() = %v", sets)
+	}
+	if ms, _ := rp.MaxStaleness(); ms != 90*time.Second {
+		t.Errorf("MaxStaleness() = %v", ms)
+	}
+
+	if _, err = (Config{ReadPreference: "nearest", MaxStaleness: 10}).readPreference(); err == nil {
+		t.Error("readPreference() should reject max_staleness less than 90")
+	}
+	if _, err = (Config{ReadPreference: "primary", ReadPreferenceTags: "dc:ny"}).readPreference(); err == nil {
+		t.Error("readPreference() should reject tags with primary")
+	}
+	if _, err = (Config{ReadPreference: "nearest", ReadPreferenceTags: "dc"}).readPreference(); err == nil {
+		t.Error("readPreference() should reject malformed tags")
+	}
+}
+
+func TestConfig_writeConcern(t *testing.T) {
+	wc, err := Config{WriteConcernW: "2", WriteConcernTimeout: 100}.writeConcern()
+	if err != nil || wc.W != 2 || wc.WTimeout != 100*time.Millisecond || wc.Journal != nil {
+		t.Fatalf("writeConcern() = %+v, %v", wc, err)
+	}
+	wc, err = Config{WriteConcernW: "majority", WriteConcernJ: true}.writeConcern()
+	if err != nil || wc.W != "majority" || !*wc.Journal {
+		t.Fatalf("writeConcern() = %+v, %v", wc, err)
+	}
+	if wc, _ = (Config{}).writeConcern(); wc != nil {
+		t.Errorf("writeConcern() = %+v, want nil", wc)
+	}
+}
+
+func TestConfig_driverOptions(t *testing.T) {
+	opt, err := Config{Hostport: "mongodb://127.0.0.1:27017", AppName: "app", ReplicaSet: "rs0", MaxConnIdleTime: 60}.
+		driverOptions(time.Second, 3)
+	if err != nil {
+		t.Fatalf("driverOptions() error = %v", err)
+	}
+	if *opt.AppName != "app" || *opt.ReplicaSet != "rs0" || *opt.MaxConnIdleTime != time.Minute || *opt.MaxPoolSize != 5 {
+		t.Errorf("driverOptions() = %+v", opt)
+	}
+	if opt.Auth != nil || opt.TLSConfig != nil {
+		t.Errorf("driverOptions() should not set auth or tls")
+	}
+
+	// hostport为空时不解析，hosts由WithDriverOptions设置
+	opt, err = Config{}.driverOptions(time.Second, 3)
+	if err != nil || opt.Validate() != nil || len(opt.Hosts) != 0 {
+		t.Errorf("driverOptions() without hostport = %+v, %v", opt, err)
+	}
+}
@@ -0,0 +1,220 @@
+package gomongodb
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Credential 用户名及密码，UserName为空时使用Config.UserName
+type Credential struct {
+	UserName string
+	Password string
+}
+
+func (c Credential) apply(cfg Config) Config {
+	if c.UserName != "" {
+		cfg.UserName = c.UserName
+	}
+	cfg.Password = c.Password
+	return cfg
+}
+
+// CredentialProvider 提供及轮换凭证，见WithCredentialProvider
+type CredentialProvider interface {
+	// Credential 获取当前的凭证，初始化Client时调用
+	Credential(ctx context.Context) (Credential, error)
+	// Watch 凭证变化时发送新的凭证，ctx结束后关闭channel
+	Watch(ctx context.Context) <-chan Credential
+}
+
+// FileCredentialProvider 从文件读取凭证，如Kubernetes挂载的Secret，定时检查文件内容的变化。
+// 文件内容首尾的空白会被去掉。
+type FileCredentialProvider struct {
+	// UserNameFile 为空时使用Config.UserName
+	UserNameFile string
+	PasswordFile string
+	// Interval 检查文件变化的间隔，默认10s
+	Interval time.Duration
+}
+
+var _ CredentialProvider = &FileCredentialProvider{}
+
+func (p *FileCredentialProvider) Credential(ctx context.Context) (cred Credential, err error) {
+	if p.UserNameFile != "" {
+		if cred.UserName, err = readCredentialFile(p.UserNameFile); err != nil {
+			return
+		}
+	}
+	cred.Password, err = readCredentialFile(p.PasswordFile)
+	return
+}
+
+func (p *FileCredentialProvider) Watch(ctx context.Context) <-chan Credential {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ch := make(chan Credential)
+	last, _ := p.Credential(ctx)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			// 文件更新过程中可能读取失败，下次再检查
+			cred, err := p.Credential(ctx)
+			if err != nil || cred == last {
+				continue
+			}
+			select {
+			case ch <- cred:
+				last = cred
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func readCredentialFile(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", errors.Wrap(err, "read credential file")
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// clientConn Client()背后的driver client，更换凭证时整体替换
+type clientConn struct {
+	client *mongo.Client
+	// inflight 在该连接上开始的操作
+	inflight sync.WaitGroup
+}
+
+// watchCredential 凭证变化时使用新凭证建立连接，成功后替换Client()，新连接失败时定时重试
+func (f *Client) watchCredential(provider CredentialProvider, cfg Config,
+	connect func(ctx context.Context, cfg Config) (*mongo.Client, error)) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.stopWatch = cancel
+	changes := provider.Watch(ctx)
+	go func() {
+		var pending *Credential
+		retry := time.NewTimer(0)
+		<-retry.C
+		for {
+			select {
+			case cred, ok := <-changes:
+				if !ok {
+					return
+				}
+				pending = &cred
+			case <-retry.C:
+			case <-ctx.Done():
+				return
+			}
+			if pending == nil {
+				continue
+			}
+			if err := f.rotate(ctx, pending.apply(cfg), connect); err != nil {
+				if errors.Is(err, ErrClientClosed) {
+					return
+				}
+				slog.Warn("gomongodb: rotate credential failed", "target", f.metricTarget, "error", err.Error())
+				retry.Reset(f.timeout)
+				continue
+			}
+			pending = nil
+		}
+	}()
+}
+
+// rotate 建立新连接并替换，旧连接在其上的操作及游标结束后断开，见retire
+func (f *Client) rotate(ctx context.Context, cfg Config,
+	connect func(ctx context.Context, cfg Config) (*mongo.Client, error)) error {
+
+	connectCtx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	conn, err := connect(connectCtx, cfg)
+	if err != nil {
+		return err
+	}
+
+	f.closeMu.Lock()
+	if f.closed {
+		f.closeMu.Unlock()
+		_ = conn.Disconnect(connectCtx)
+		return ErrClientClosed
+	}
+	old := f.conn.Swap(&clientConn{client: conn})
+	if f.retireStop == nil {
+		f.retireStop = make(chan struct{})
+	}
+	stop := f.retireStop
+	f.retiring.Add(1)
+	f.closeMu.Unlock()
+
+	go func() {
+		defer f.retiring.Done()
+		old.retire(f.cursors, DEFAULT_CREDENTIAL_RETIRE_TIMEOUT, stop)
+	}()
+	return nil
+}
+
+// retire 等待该连接上进行中的操作结束，最多等待timeout；再等待该连接上FindCursor返回的游标读完、关闭或被服务端回收，
+// 之后断开。stop关闭时（Client.Close）不再等待，立即断开
+func (c *clientConn) retire(cursors *cursorTracker, timeout time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	inflightCtx, inflightCancel := context.WithTimeout(ctx, timeout)
+	defer inflightCancel()
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-inflightCtx.Done():
+	}
+	if cursors != nil {
+		_ = cursors.wait(ctx, c)
+	}
+
+	disconnectCtx, disconnectCancel := context.WithTimeout(context.Background(), timeout)
+	defer disconnectCancel()
+	_ = c.client.Disconnect(disconnectCtx)
+}
+
+// stopRetiring 停止等待更换凭证后的旧连接，等待它们断开
+func (f *Client) stopRetiring() {
+	f.closeMu.Lock()
+	stop := f.retireStop
+	f.retireStop = nil
+	f.closeMu.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+	f.retiring.Wait()
+}
@@ -0,0 +1,130 @@
+package gomongodb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestFileCredentialProvider(t *testing.T) {
+	dir := t.TempDir()
+	userFile, passFile := filepath.Join(dir, "username"), filepath.Join(dir, "password")
+	if err := os.WriteFile(userFile, []byte("user\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passFile, []byte("pass1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &FileCredentialProvider{UserNameFile: userFile, PasswordFile: passFile, Interval: 5 * time.Millisecond}
+	cred, err := p.Credential(context.Background())
+	if err != nil || cred != (Credential{UserName: "user", Password: "pass1"}) {
+		t.Fatalf("Credential() = %+v, %v", cred, err)
+	}
+	if cfg := cred.apply(Config{UserName: "old", Password: "old"}); cfg.UserName != "user" || cfg.Password != "pass1" {
+		t.Errorf("apply() = %+v", cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := p.Watch(ctx)
+	if err := os.WriteFile(passFile, []byte("pass2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cred = <-changes:
+		if cred.Password != "pass2" {
+			t.Errorf("Watch() got %+v", cred)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watch() should notice the change")
+	}
+	cancel()
+	for range changes {
+	}
+}
+
+func TestClient_rotate(t *testing.T) {
+	newConn := func(ctx context.Context, cfg Config) (*mongo.Client, error) {
+		// 不会立即建立连接
+		return mongo.Connect(ctx, options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	}
+	conn, err := newConn(context.Background(), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClientForTest(&recordSink{})
+	client.cursors = newCursorTracker()
+	client.conn.Store(&clientConn{client: conn})
+
+	_, done, err := client.begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := client.conn.Load()
+	cursor := cursorKey{address: "127.0.0.1:1", id: 1}
+	client.cursors.add(cursor, old)
+	if err := client.rotate(context.Background(), Config{}, newConn); err != nil {
+		t.Fatalf("rotate() error = %v", err)
+	}
+	if client.Client() == conn {
+		t.Fatal("Client() should be swapped")
+	}
+
+	// 旧连接等待进行中的操作及其上的游标结束后才断开
+	retired := make(chan struct{})
+	go func() {
+		client.retiring.Wait()
+		close(retired)
+	}()
+	notRetired := func(reason string) {
+		select {
+		case <-retired:
+			t.Fatalf("old connection retired before %s", reason)
+		case <-time.After(30 * time.Millisecond):
+		}
+	}
+	notRetired("in-flight operation finished")
+	done()
+	notRetired("open cursor closed")
+	// 新连接上的游标不影响旧连接
+	client.cursors.add(cursorKey{address: "127.0.0.1:1", id: 2}, client.conn.Load())
+	client.cursors.remove(cursor)
+	select {
+	case <-retired:
+	case <-time.After(time.Second):
+		t.Fatal("old connection should retire after its cursors closed")
+	}
+	if err := conn.Ping(context.Background(), nil); !errors.Is(err, mongo.ErrClientDisconnected) {
+		t.Errorf("old connection Ping() error = %v, want ErrClientDisconnected", err)
+	}
+
+	// Close时不再等待旧连接上的游标
+	old = client.conn.Load()
+	if err := client.rotate(context.Background(), Config{}, newConn); err != nil {
+		t.Fatalf("rotate() error = %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		client.stopRetiring()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stopRetiring() should disconnect retiring connections")
+	}
+	if err := old.client.Ping(context.Background(), nil); !errors.Is(err, mongo.ErrClientDisconnected) {
+		t.Errorf("retired connection Ping() error = %v, want ErrClientDisconnected", err)
+	}
+
+	client.closed = true
+	if err := client.rotate(context.Background(), Config{}, newConn); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("rotate() error = %v, want ErrClientClosed", err)
+	}
+}
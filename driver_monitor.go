@@ -0,0 +1,298 @@
+package gomongodb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// driverMetrics driver层面的metrics，覆盖Client().直接调用、FindCursor的遍历、事务等wrapper监控不到的场景
+type driverMetrics struct {
+	commandLatency  *prometheus.HistogramVec
+	commandError    *prometheus.CounterVec
+	checkoutWait    *prometheus.HistogramVec
+	poolSize        *prometheus.GaugeVec
+	poolInUse       *prometheus.GaugeVec
+	connectionError *prometheus.CounterVec
+	heartbeatRTT    *prometheus.HistogramVec
+	heartbeatError  *prometheus.CounterVec
+}
+
+func newDriverMetrics(opt metricOptions) (m *driverMetrics, err error) {
+	m = &driverMetrics{}
+	m.commandLatency, err = registHistogram(opt.registerer, prometheus.HistogramOpts{
+		Namespace:                   opt.namespace,
+		Subsystem:                   opt.subsystem,
+		Name:                        "mongo_driver_command_latency",
+		Help:                        "Histogram of mongo driver command",
+		ConstLabels:                 opt.constLabels,
+		Buckets:                     opt.buckets,
+		NativeHistogramBucketFactor: opt.nativeHistogramBucketFactor,
+	}, []string{"target", "command", "db", "collection"})
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.commandError, err = registMetrics(opt.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_driver_command_error",
+		Help:        "Counter of mongo driver command failure",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "command", "db", "collection"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.checkoutWait, err = registHistogram(opt.registerer, prometheus.HistogramOpts{
+		Namespace:                   opt.namespace,
+		Subsystem:                   opt.subsystem,
+		Name:                        "mongo_driver_pool_checkout_wait",
+		Help:                        "Histogram of waiting for a connection checkout",
+		ConstLabels:                 opt.constLabels,
+		Buckets:                     opt.buckets,
+		NativeHistogramBucketFactor: opt.nativeHistogramBucketFactor,
+	}, []string{"target", "host"})
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.poolSize, err = registMetrics(opt.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_driver_pool_size",
+		Help:        "Gauge of open connections in driver pool",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "host"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.poolInUse, err = registMetrics(opt.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_driver_pool_in_use",
+		Help:        "Gauge of checked out connections in driver pool",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "host"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.connectionError, err = registMetrics(opt.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_driver_connection_error",
+		Help:        "Counter of connection checkout failure and connection closed by error",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "host", "reason"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.heartbeatRTT, err = registHistogram(opt.registerer, prometheus.HistogramOpts{
+		Namespace:                   opt.namespace,
+		Subsystem:                   opt.subsystem,
+		Name:                        "mongo_driver_server_heartbeat_rtt",
+		Help:                        "Histogram of server heartbeat round trip time",
+		ConstLabels:                 opt.constLabels,
+		Buckets:                     opt.buckets,
+		NativeHistogramBucketFactor: opt.nativeHistogramBucketFactor,
+	}, []string{"target", "host"})
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.heartbeatError, err = registMetrics(opt.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_driver_server_heartbeat_error",
+		Help:        "Counter of server heartbeat failure",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "host"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	return m, nil
+}
+
+// driverMonitor 安装到driver上的各类monitor，会保留调用方通过driver options设置的monitor
+type driverMonitor struct {
+	target  string
+	metrics *driverMetrics
+	// client在Connect之后才创建，之前的事件不做label转换
+	client atomic.Pointer[Client]
+	// 命令结束的事件中没有collection，按RequestID暂存
+	collections sync.Map
+}
+
+func newDriverMonitor(opt metricOptions, target string) (*driverMonitor, error) {
+	metrics, err := newDriverMetrics(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &driverMonitor{
+		target:  target,
+		metrics: metrics,
+	}, nil
+}
+
+func (m *driverMonitor) convertLabel(label string) string {
+	if client := m.client.Load(); client != nil {
+		return client.convertMetricsLabel(label)
+	}
+	return label
+}
+
+// clientOptions 生成安装monitor的driver options，需放在opts的最后，opts中已有的monitor会被串联调用
+func (m *driverMonitor) clientOptions(opts []*options.ClientOptions) *options.ClientOptions {
+	var (
+		userCommand *event.CommandMonitor
+		userPool    *event.PoolMonitor
+		userServer  *event.ServerMonitor
+	)
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Monitor != nil {
+			userCommand = opt.Monitor
+		}
+		if opt.PoolMonitor != nil {
+			userPool = opt.PoolMonitor
+		}
+		if opt.ServerMonitor != nil {
+			userServer = opt.ServerMonitor
+		}
+	}
+	return options.Client().
+		SetMonitor(m.commandMonitor(userCommand)).
+		SetPoolMonitor(m.poolMonitor(userPool)).
+		SetServerMonitor(m.serverMonitor(userServer))
+}
+
+func (m *driverMonitor) commandMonitor(user *event.CommandMonitor) *event.CommandMonitor {
+	if user == nil {
+		user = &event.CommandMonitor{}
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			m.collections.Store(e.RequestID, commandCollection(e.CommandName, e.Command))
+			if user.Started != nil {
+				user.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			m.observeCommand(e.CommandFinishedEvent, false)
+			if user.Succeeded != nil {
+				user.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			m.observeCommand(e.CommandFinishedEvent, true)
+			if user.Failed != nil {
+				user.Failed(ctx, e)
+			}
+		},
+	}
+}
+
+func (m *driverMonitor) observeCommand(e event.CommandFinishedEvent, failed bool) {
+	collection := ""
+	if v, ok := m.collections.LoadAndDelete(e.RequestID); ok {
+		collection = v.(string)
+	}
+	labels := prometheus.Labels{
+		"target":     m.target,
+		"command":    e.CommandName,
+		"db":         m.convertLabel(e.DatabaseName),
+		"collection": m.convertLabel(collection),
+	}
+	if failed {
+		m.metrics.commandError.With(labels).Inc()
+	}
+	m.metrics.commandLatency.With(labels).Observe(float64(e.Duration.Milliseconds()))
+}
+
+func (m *driverMonitor) poolMonitor(user *event.PoolMonitor) *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			m.observePool(e)
+			if user != nil && user.Event != nil {
+				user.Event(e)
+			}
+		},
+	}
+}
+
+func (m *driverMonitor) observePool(e *event.PoolEvent) {
+	switch e.Type {
+	case event.ConnectionCreated:
+		m.metrics.poolSize.WithLabelValues(m.target, e.Address).Inc()
+	case event.ConnectionClosed:
+		m.metrics.poolSize.WithLabelValues(m.target, e.Address).Dec()
+		if e.Reason == event.ReasonError || e.Reason == event.ReasonConnectionErrored {
+			m.metrics.connectionError.WithLabelValues(m.target, e.Address, e.Reason).Inc()
+		}
+	case event.GetSucceeded:
+		m.metrics.poolInUse.WithLabelValues(m.target, e.Address).Inc()
+		m.metrics.checkoutWait.WithLabelValues(m.target, e.Address).Observe(float64(e.Duration.Milliseconds()))
+	case event.GetFailed:
+		m.metrics.connectionError.WithLabelValues(m.target, e.Address, e.Reason).Inc()
+		m.metrics.checkoutWait.WithLabelValues(m.target, e.Address).Observe(float64(e.Duration.Milliseconds()))
+	case event.ConnectionReturned:
+		m.metrics.poolInUse.WithLabelValues(m.target, e.Address).Dec()
+	}
+}
+
+// serverMonitor 只记录非await的心跳耗时，streaming协议下await的心跳耗时包含了服务端的等待时间。
+// 服务端4.4以上默认使用streaming协议，如需稳定的RTT采样，可通过driver options将ServerMonitoringMode设为poll。
+func (m *driverMonitor) serverMonitor(user *event.ServerMonitor) *event.ServerMonitor {
+	monitor := &event.ServerMonitor{}
+	if user != nil {
+		*monitor = *user
+	}
+	userSucceeded, userFailed := monitor.ServerHeartbeatSucceeded, monitor.ServerHeartbeatFailed
+	monitor.ServerHeartbeatSucceeded = func(e *event.ServerHeartbeatSucceededEvent) {
+		if !e.Awaited {
+			m.metrics.heartbeatRTT.WithLabelValues(m.target, connectionHost(e.ConnectionID)).
+				Observe(float64(e.Duration.Milliseconds()))
+		}
+		if userSucceeded != nil {
+			userSucceeded(e)
+		}
+	}
+	monitor.ServerHeartbeatFailed = func(e *event.ServerHeartbeatFailedEvent) {
+		m.metrics.heartbeatError.WithLabelValues(m.target, connectionHost(e.ConnectionID)).Inc()
+		if userFailed != nil {
+			userFailed(e)
+		}
+	}
+	return monitor
+}
+
+// commandCollection 从命令中解析collection，如{find: "col"}、{getMore: 1, collection: "col"}
+func commandCollection(commandName string, command bson.Raw) string {
+	if commandName == "getMore" {
+		if v, err := command.LookupErr("collection"); err == nil {
+			if s, ok := v.StringValueOK(); ok {
+				return s
+			}
+		}
+		return ""
+	}
+	if v, err := command.LookupErr(commandName); err == nil {
+		if s, ok := v.StringValueOK(); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// connectionHost 从driver事件的ConnectionID（host:port[-N]）中取出server的地址，IPv6地址本身带有[]
+func connectionHost(connectionID string) string {
+	if idx := strings.LastIndex(connectionID, "[-"); idx > 0 {
+		return connectionID[:idx]
+	}
+	return connectionID
+}
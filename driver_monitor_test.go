@@ -0,0 +1,157 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newDriverMonitorForTest(t *testing.T) *driverMonitor {
+	opts := defaultClientOptions()
+	WithRegisterer(prometheus.NewRegistry())(&opts)
+	m, err := newDriverMonitor(opts.metric, "target")
+	if err != nil {
+		t.Fatalf("newDriverMonitor() error = %v", err)
+	}
+	return m
+}
+
+func Test_driverMonitor_command(t *testing.T) {
+	m := newDriverMonitorForTest(t)
+	client := &Client{}
+	client.AddMetricsLabelConverter(func(label string) (string, bool) {
+		return "col_x", label == "col_1"
+	})
+	m.client.Store(client)
+
+	userCalled := 0
+	opt := m.clientOptions([]*options.ClientOptions{
+		options.Client().SetMonitor(&event.CommandMonitor{
+			Succeeded: func(context.Context, *event.CommandSucceededEvent) { userCalled++ },
+		}),
+	})
+
+	command, _ := bson.Marshal(bson.D{{Key: "find", Value: "col_1"}})
+	opt.Monitor.Started(context.Background(), &event.CommandStartedEvent{
+		Command: command, DatabaseName: "db", CommandName: "find", RequestID: 1,
+	})
+	opt.Monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{
+			CommandName: "find", DatabaseName: "db", RequestID: 1, Duration: time.Millisecond,
+		},
+	})
+	if userCalled != 1 {
+		t.Errorf("user monitor called %d times, want 1", userCalled)
+	}
+	if n := testutil.CollectAndCount(m.metrics.commandLatency); n != 1 {
+		t.Errorf("commandLatency series = %d, want 1", n)
+	}
+	if n := testutil.CollectAndCount(m.metrics.commandLatency.WithLabelValues("target", "find", "db", "col_x").(prometheus.Histogram)); n != 1 {
+		t.Errorf("commandLatency with converted label = %d, want 1", n)
+	}
+}
+
+func Test_driverMonitor_pool(t *testing.T) {
+	m := newDriverMonitorForTest(t)
+	pool := m.clientOptions(nil).PoolMonitor
+	for _, e := range []*event.PoolEvent{
+		{Type: event.ConnectionCreated, Address: "h1:27017"},
+		{Type: event.ConnectionCreated, Address: "h1:27017"},
+		{Type: event.GetSucceeded, Address: "h1:27017", Duration: time.Millisecond},
+		{Type: event.GetSucceeded, Address: "h1:27017", Duration: time.Millisecond},
+		{Type: event.ConnectionReturned, Address: "h1:27017"},
+		{Type: event.GetFailed, Address: "h1:27017", Reason: event.ReasonTimedOut},
+		{Type: event.ConnectionClosed, Address: "h1:27017", Reason: event.ReasonError},
+	} {
+		pool.Event(e)
+	}
+	if v := testutil.ToFloat64(m.metrics.poolSize.WithLabelValues("target", "h1:27017")); v != 1 {
+		t.Errorf("poolSize = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(m.metrics.poolInUse.WithLabelValues("target", "h1:27017")); v != 1 {
+		t.Errorf("poolInUse = %v, want 1", v)
+	}
+	if n := testutil.CollectAndCount(m.metrics.connectionError); n != 2 {
+		t.Errorf("connectionError series = %d, want 2", n)
+	}
+}
+
+func Test_driverMonitor_server(t *testing.T) {
+	m := newDriverMonitorForTest(t)
+	opened := false
+	server := m.clientOptions([]*options.ClientOptions{
+		options.Client().SetServerMonitor(&event.ServerMonitor{
+			ServerOpening: func(*event.ServerOpeningEvent) { opened = true },
+		}),
+	}).ServerMonitor
+	server.ServerOpening(&event.ServerOpeningEvent{})
+	server.ServerHeartbeatSucceeded(&event.ServerHeartbeatSucceededEvent{ConnectionID: "h1:27017[-1]", Duration: time.Millisecond})
+	server.ServerHeartbeatSucceeded(&event.ServerHeartbeatSucceededEvent{ConnectionID: "h1:27017[-1]", Duration: 10 * time.Second, Awaited: true})
+	if !opened {
+		t.Errorf("user server monitor not called")
+	}
+	if n := testutil.CollectAndCount(m.metrics.heartbeatRTT); n != 1 {
+		t.Errorf("heartbeatRTT series = %d, want 1", n)
+	}
+}
+
+func Test_commandCollection(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandName string
+		command     bson.D
+		want        string
+	}{
+		{
+			name:        "find",
+			commandName: "find",
+			command:     bson.D{{Key: "find", Value: "col"}, {Key: "filter", Value: bson.D{}}},
+			want:        "col",
+		},
+		{
+			name:        "getMore",
+			commandName: "getMore",
+			command:     bson.D{{Key: "getMore", Value: int64(1)}, {Key: "collection", Value: "col"}},
+			want:        "col",
+		},
+		{
+			name:        "db level aggregate",
+			commandName: "aggregate",
+			command:     bson.D{{Key: "aggregate", Value: 1}},
+			want:        "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := bson.Marshal(tt.command)
+			if got := commandCollection(tt.commandName, raw); got != tt.want {
+				t.Errorf("commandCollection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_connectionHost(t *testing.T) {
+	tests := []struct {
+		connectionID string
+		want         string
+	}{
+		{"localhost:27017[-3]", "localhost:27017"},
+		{"h1:27017[-12]", "h1:27017"},
+		{"[::1]:27017[-12]", "[::1]:27017"},
+		{"[::1]:27017", "[::1]:27017"},
+		{"a:1", "a:1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := connectionHost(tt.connectionID); got != tt.want {
+			t.Errorf("connectionHost(%q) = %q, want %q", tt.connectionID, got, tt.want)
+		}
+	}
+}
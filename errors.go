@@ -0,0 +1,271 @@
+package gomongodb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+)
+
+// 可通过errors.Is判断wrapper返回的错误类型，ErrPoolExhausted、ErrCircuitOpen、ErrClientClosed见各自的定义
+var (
+	// ErrDuplicateKey 唯一索引冲突，可通过errors.As获取*DuplicateKeyError
+	ErrDuplicateKey = errors.New("gomongodb: duplicate key")
+	// ErrTimeout 操作超时，包括ctx的deadline、服务端的maxTimeMS及网络超时
+	ErrTimeout = errors.New("gomongodb: timeout")
+	// ErrNetwork 网络错误
+	ErrNetwork = errors.New("gomongodb: network error")
+	// ErrWriteConflict 事务中的写冲突
+	ErrWriteConflict = errors.New("gomongodb: write conflict")
+	// ErrDocumentTooLarge 文档超过16MB
+	ErrDocumentTooLarge = errors.New("gomongodb: document too large")
+	// ErrUpdateRejected update语句未通过updateSafeCheck的校验
+	ErrUpdateRejected = errors.New("gomongodb: update rejected")
+)
+
+// OperationError wrapper返回的错误，附带db、collection及方法名。
+// 兼容errors.Is/As及pkg/errors的Cause，均可得到driver返回的原始错误。
+type OperationError struct {
+	Database   string
+	Collection string
+	// Operation wrapper的方法名，如Find、UpdateID
+	Operation string
+	Err       error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("%s %s.%s: %v", e.Operation, e.Database, e.Collection, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *OperationError) Cause() error {
+	return e.Err
+}
+
+func (e *OperationError) Is(target error) bool {
+	switch target {
+	case ErrTimeout:
+		return mongo.IsTimeout(e.Err)
+	case ErrNetwork:
+		return mongo.IsNetworkError(e.Err)
+	case ErrWriteConflict:
+		return hasServerErrorCode(e.Err, 112)
+	case ErrDocumentTooLarge:
+		// BSONObjectTooLarge，及update、upsert后的文档过大
+		return errors.Is(e.Err, driver.ErrDocumentTooLarge) || hasServerErrorCode(e.Err, 10334, 17419, 17420)
+	}
+	return false
+}
+
+func hasServerErrorCode(err error, codes ...int) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	for _, code := range codes {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapOperationError 为错误附带操作信息，闭包中嵌套调用返回的错误已附带时不再重复
+func wrapOperationError(op *Operation, err error) error {
+	if err == nil {
+		return nil
+	}
+	var oe *OperationError
+	if errors.As(err, &oe) {
+		return err
+	}
+	if dk := parseDuplicateKey(err); dk != nil {
+		err = dk
+	}
+	return &OperationError{Database: op.Database, Collection: op.Collection, Operation: op.Name, Err: err}
+}
+
+// DuplicateKeyError 唯一索引冲突的详细信息
+type DuplicateKeyError struct {
+	// Index 冲突的索引名
+	Index string
+	// KeyPattern 索引的定义，如{"name": 1}，服务端4.2以下为空
+	KeyPattern bson.M
+	// KeyValue 冲突的值，如{"name": "foo"}，服务端4.2以下为空
+	KeyValue bson.M
+	Err      error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DuplicateKeyError) Cause() error {
+	return e.Err
+}
+
+func (e *DuplicateKeyError) Is(target error) bool {
+	return target == ErrDuplicateKey
+}
+
+var duplicateKeyIndexRegexp = regexp.MustCompile(`index: (\S+) dup key`)
+
+// parseDuplicateKey 不是唯一索引冲突时返回nil
+func parseDuplicateKey(err error) *DuplicateKeyError {
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	var (
+		message string
+		raw     bson.Raw
+	)
+	isDuplicateKeyCode := func(code int) bool {
+		return code == 11000 || code == 11001 || code == 12582
+	}
+	var we mongo.WriteException
+	var bwe mongo.BulkWriteException
+	var ce mongo.CommandError
+	switch {
+	case errors.As(err, &we):
+		for _, e := range we.WriteErrors {
+			if isDuplicateKeyCode(e.Code) {
+				message, raw = e.Message, e.Raw
+				break
+			}
+		}
+	case errors.As(err, &bwe):
+		for _, e := range bwe.WriteErrors {
+			if isDuplicateKeyCode(e.Code) {
+				message, raw = e.Message, e.Raw
+				break
+			}
+		}
+	case errors.As(err, &ce):
+		message, raw = ce.Message, ce.Raw
+	}
+
+	dk := &DuplicateKeyError{Err: err}
+	if m := duplicateKeyIndexRegexp.FindStringSubmatch(message); m != nil {
+		dk.Index = m[1]
+	}
+	if len(raw) > 0 {
+		var detail struct {
+			KeyPattern bson.M `bson:"keyPattern"`
+			KeyValue   bson.M `bson:"keyValue"`
+		}
+		if bson.Unmarshal(raw, &detail) == nil {
+			dk.KeyPattern, dk.KeyValue = detail.KeyPattern, detail.KeyValue
+		}
+	}
+	return dk
+}
+
+// UpdateRejectedError update语句未通过校验的原因
+type UpdateRejectedError struct {
+	Reason error
+}
+
+func (e *UpdateRejectedError) Error() string {
+	return e.Reason.Error()
+}
+
+func (e *UpdateRejectedError) Unwrap() error {
+	return e.Reason
+}
+
+func (e *UpdateRejectedError) Is(target error) bool {
+	return target == ErrUpdateRejected
+}
+
+// ErrorType metrics中error_type label的取值
+const (
+	ErrorTypeContextCanceled = "context_canceled"
+	ErrorTypeTimeout         = "timeout"
+	ErrorTypeNetwork         = "network"
+	ErrorTypeDuplicateKey    = "duplicate_key"
+	ErrorTypeWriteConflict   = "write_conflict"
+	ErrorTypeValidation      = "validation"
+	ErrorTypeUpdateRejected  = "update_rejected"
+	ErrorTypePoolExhausted   = "pool_exhausted"
+	ErrorTypeCircuitOpen     = "circuit_open"
+	ErrorTypeClientClosed    = "client_closed"
+	ErrorTypeOther           = "other"
+)
+
+// ErrorType 对错误分类，用作metrics的error_type label。
+// 其他服务端错误为server_code_N，N为错误码，如认证失败为server_code_18；err为nil时返回空字符串。
+func ErrorType(err error) string {
+	if err == nil {
+		return ""
+	}
+	oe := &OperationError{Err: err}
+	var se mongo.ServerError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// 调用方取消，不是mongo的问题
+		return ErrorTypeContextCanceled
+	case errors.Is(err, ErrUpdateRejected):
+		return ErrorTypeUpdateRejected
+	case errors.Is(err, ErrPoolExhausted):
+		return ErrorTypePoolExhausted
+	case errors.Is(err, ErrCircuitOpen):
+		return ErrorTypeCircuitOpen
+	case errors.Is(err, ErrClientClosed):
+		return ErrorTypeClientClosed
+	case mongo.IsDuplicateKeyError(err):
+		return ErrorTypeDuplicateKey
+	case errors.Is(oe, ErrWriteConflict):
+		return ErrorTypeWriteConflict
+	case hasServerErrorCode(err, 121), errors.Is(err, builder.ErrUnknownField):
+		// DocumentValidationFailure，或builder中的字段不存在
+		return ErrorTypeValidation
+	case errors.Is(oe, ErrTimeout):
+		return ErrorTypeTimeout
+	case errors.Is(oe, ErrNetwork):
+		return ErrorTypeNetwork
+	case errors.As(err, &se):
+		if code := serverErrorCode(se); code != 0 {
+			return fmt.Sprintf("server_code_%d", code)
+		}
+	}
+	return ErrorTypeOther
+}
+
+// serverErrorCode 第一个非0的错误码
+func serverErrorCode(se mongo.ServerError) int {
+	switch e := se.(type) {
+	case mongo.CommandError:
+		return int(e.Code)
+	case mongo.WriteException:
+		if e.WriteConcernError != nil && e.WriteConcernError.Code != 0 {
+			return e.WriteConcernError.Code
+		}
+		for _, we := range e.WriteErrors {
+			if we.Code != 0 {
+				return we.Code
+			}
+		}
+	case mongo.BulkWriteException:
+		if e.WriteConcernError != nil && e.WriteConcernError.Code != 0 {
+			return e.WriteConcernError.Code
+		}
+		for _, we := range e.WriteErrors {
+			if we.Code != 0 {
+				return we.Code
+			}
+		}
+	}
+	return 0
+}
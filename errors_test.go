@@ -0,0 +1,138 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+)
+
+func TestOperationError_Is(t *testing.T) {
+	op := &Operation{Database: "db", Collection: "col", Name: "Find"}
+	tests := []struct {
+		name   string
+		err    error
+		target error
+	}{
+		{"timeout", context.DeadlineExceeded, ErrTimeout},
+		{"network", mongo.CommandError{Labels: []string{"NetworkError"}}, ErrNetwork},
+		{"write conflict", mongo.CommandError{Code: 112, Name: "WriteConflict"}, ErrWriteConflict},
+		{"too large client side", driver.ErrDocumentTooLarge, ErrDocumentTooLarge},
+		{"too large server side", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 17419}}}, ErrDocumentTooLarge},
+		{"duplicate key", mongo.CommandError{Code: 11000}, ErrDuplicateKey},
+		{"update rejected", &UpdateRejectedError{Reason: errors.New("update语句只接受bson.M类型")}, ErrUpdateRejected},
+		{"pool exhausted", &PoolExhaustedError{Target: "test"}, ErrPoolExhausted},
+		{"original error", context.DeadlineExceeded, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wrapOperationError(op, tt.err)
+			if !errors.Is(err, tt.target) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.target)
+			}
+			if errors.Is(err, ErrNetwork) && tt.target != ErrNetwork {
+				t.Errorf("errors.Is(%v, ErrNetwork) = true", err)
+			}
+		})
+	}
+
+	err := wrapOperationError(op, errors.Wrap(context.Canceled, "wait pool"))
+	var oe *OperationError
+	if !errors.As(err, &oe) || oe.Database != "db" || oe.Collection != "col" || oe.Operation != "Find" {
+		t.Fatalf("errors.As() = %#v", err)
+	}
+	if err.Error() != "Find db.col: wait pool: context canceled" {
+		t.Errorf("Error() = %s", err)
+	}
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("errors.Cause() = %v", errors.Cause(err))
+	}
+
+	// UseSession闭包中嵌套调用返回的错误不再重复附带
+	outer := wrapOperationError(&Operation{Name: "UseSession"}, errors.Wrap(err, "closure"))
+	if !errors.As(outer, &oe) || oe.Operation != "Find" {
+		t.Errorf("nested operation error should not be wrapped again: %v", outer)
+	}
+}
+
+func Test_parseDuplicateKey(t *testing.T) {
+	raw, _ := bson.Marshal(bson.M{
+		"index":      0,
+		"code":       11000,
+		"errmsg":     `E11000 duplicate key error collection: db.col index: name_1 dup key: { name: "foo" }`,
+		"keyPattern": bson.M{"name": 1},
+		"keyValue":   bson.M{"name": "foo"},
+	})
+	err := mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    11000,
+		Message: `E11000 duplicate key error collection: db.col index: name_1 dup key: { name: "foo" }`,
+		Raw:     raw,
+	}}}
+	dk := parseDuplicateKey(err)
+	if dk == nil || dk.Index != "name_1" || dk.KeyPattern["name"] != int32(1) || dk.KeyValue["name"] != "foo" {
+		t.Fatalf("parseDuplicateKey() = %+v", dk)
+	}
+
+	wrapped := wrapOperationError(&Operation{Name: "InsertOne"}, err)
+	var target *DuplicateKeyError
+	if !errors.As(wrapped, &target) || target.Index != "name_1" {
+		t.Errorf("errors.As() = %v", wrapped)
+	}
+	if !mongo.IsDuplicateKeyError(wrapped) {
+		t.Errorf("mongo.IsDuplicateKeyError() should still work")
+	}
+
+	// 4.2以下的服务端只有errmsg
+	dk = parseDuplicateKey(mongo.CommandError{Code: 11000, Message: "E11000 duplicate key error index: db.col.$uid_1 dup key: { : 1 }"})
+	if dk == nil || dk.Index != "db.col.$uid_1" || dk.KeyValue != nil {
+		t.Errorf("parseDuplicateKey() = %+v", dk)
+	}
+	if parseDuplicateKey(errors.New("other")) != nil {
+		t.Errorf("parseDuplicateKey() should return nil")
+	}
+}
+
+func Test_invokeOperationError(t *testing.T) {
+	c := &collectionWrapper{client: newClientForTest(&recordSink{}), database: "db", collection: "col"}
+	err := c.invoke(context.Background(), c.newOperation("UpdateOne", "update"), func(ctx context.Context, op *Operation) error {
+		return &UpdateRejectedError{Reason: updateSafeCheck("bad")}
+	})
+	var oe *OperationError
+	if !errors.Is(err, ErrUpdateRejected) || !errors.As(err, &oe) || oe.Operation != "UpdateOne" {
+		t.Errorf("invoke() error = %v", err)
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.Wrap(context.Canceled, "wait pool"), ErrorTypeContextCanceled},
+		{context.DeadlineExceeded, ErrorTypeTimeout},
+		{mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}, ErrorTypeTimeout},
+		{mongo.CommandError{Labels: []string{"NetworkError"}}, ErrorTypeNetwork},
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, ErrorTypeDuplicateKey},
+		{mongo.CommandError{Code: 112}, ErrorTypeWriteConflict},
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121}}}, ErrorTypeValidation},
+		{&UpdateRejectedError{Reason: errors.New("update语句只接受bson.M类型")}, ErrorTypeUpdateRejected},
+		{&PoolExhaustedError{}, ErrorTypePoolExhausted},
+		{&CircuitOpenError{}, ErrorTypeCircuitOpen},
+		{ErrClientClosed, ErrorTypeClientClosed},
+		{mongo.CommandError{Code: 18, Name: "AuthenticationFailed"}, "server_code_18"},
+		{mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: 100}}, "server_code_100"},
+		{wrapOperationError(&Operation{Name: "Find"}, mongo.CommandError{Code: 13}), "server_code_13"},
+		{errors.Wrap(builder.ErrUnknownField, `"lieks"`), ErrorTypeValidation},
+		{errors.New("other"), ErrorTypeOther},
+	}
+	for _, tt := range tests {
+		if got := ErrorType(tt.err); got != tt.want {
+			t.Errorf("ErrorType(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package gomongodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// 部署方式，见HealthStatus.Topology
+const (
+	TopologyStandalone = "standalone"
+	TopologyReplicaSet = "replicaset"
+	TopologySharded    = "sharded"
+)
+
+// HealthStatus Client.HealthCheck的结果
+type HealthStatus struct {
+	Name string
+	// PrimaryReachable 能否访问primary，sharded时为mongos
+	PrimaryReachable bool
+	Topology         string
+	SetName          string
+	Primary          string
+	// Secondaries 状态正常的secondary，没有replSetGetStatus权限时为配置中的其他节点
+	Secondaries []string
+	// RTT 访问primary的耗时
+	RTT time.Duration
+	// ReplicationLag 各secondary落后primary的时间，只在从secondary读取时检查
+	ReplicationLag map[string]time.Duration
+	// LagError 获取复制延迟失败的原因，如没有clusterMonitor权限
+	LagError  string
+	Error     string
+	CheckedAt time.Time
+}
+
+// MaxReplicationLag 所有secondary中最大的复制延迟
+func (s *HealthStatus) MaxReplicationLag() time.Duration {
+	var max time.Duration
+	for _, lag := range s.ReplicationLag {
+		if lag > max {
+			max = lag
+		}
+	}
+	return max
+}
+
+// MarshalJSON 耗时输出为1.5ms的格式
+func (s *HealthStatus) MarshalJSON() ([]byte, error) {
+	type alias HealthStatus
+	lag := make(map[string]string, len(s.ReplicationLag))
+	for host, d := range s.ReplicationLag {
+		lag[host] = d.String()
+	}
+	return json.Marshal(struct {
+		*alias
+		RTT            string            `json:"RTT"`
+		ReplicationLag map[string]string `json:"ReplicationLag,omitempty"`
+	}{
+		alias:          (*alias)(s),
+		RTT:            s.RTT.String(),
+		ReplicationLag: lag,
+	})
+}
+
+type helloResult struct {
+	IsWritablePrimary bool     `bson:"isWritablePrimary"`
+	IsMaster          bool     `bson:"ismaster"`
+	Msg               string   `bson:"msg"`
+	SetName           string   `bson:"setName"`
+	Primary           string   `bson:"primary"`
+	Me                string   `bson:"me"`
+	Hosts             []string `bson:"hosts"`
+}
+
+type replSetStatus struct {
+	Members []struct {
+		Name       string    `bson:"name"`
+		StateStr   string    `bson:"stateStr"`
+		Health     float64   `bson:"health"`
+		OptimeDate time.Time `bson:"optimeDate"`
+	} `bson:"members"`
+}
+
+// HealthCheck 检查primary能否访问，并获取拓扑、RTT，从secondary读取时还会获取各secondary的复制延迟。
+// primary不能访问时返回error，此时HealthStatus中仍包含已获取到的信息。
+func (f *Client) HealthCheck(ctx context.Context) (*HealthStatus, error) {
+	status := &HealthStatus{Name: f.metricTarget, CheckedAt: time.Now()}
+	f.closeMu.RLock()
+	closed := f.closed
+	f.closeMu.RUnlock()
+	if closed {
+		status.Error = ErrClientClosed.Error()
+		return status, ErrClientClosed
+	}
+
+	admin := f.Client().Database("admin")
+	primary := options.RunCmd().SetReadPreference(readpref.Primary())
+	var hello helloResult
+	st := time.Now()
+	err := admin.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}, primary).Decode(&hello)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 59 {
+		// CommandNotFound，4.4.2以下的版本只支持isMaster
+		st = time.Now()
+		err = admin.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}, primary).Decode(&hello)
+	}
+	status.RTT = time.Since(st)
+	if err != nil {
+		status.Error = err.Error()
+		return status, errors.Wrap(err, "hello")
+	}
+
+	switch {
+	case hello.Msg == "isdbgrid":
+		status.Topology = TopologySharded
+		status.Primary = hello.Me
+		status.PrimaryReachable = true
+		return status, nil
+	case hello.SetName == "":
+		status.Topology = TopologyStandalone
+		status.Primary = hello.Me
+		status.PrimaryReachable = true
+		return status, nil
+	}
+	status.Topology = TopologyReplicaSet
+	status.SetName = hello.SetName
+	status.Primary = hello.Primary
+	if !hello.IsWritablePrimary && !hello.IsMaster {
+		// 主从切换过程中，选中的节点已不再是primary
+		status.Error = "no writable primary"
+		return status, errors.New("gomongodb: no writable primary")
+	}
+	status.PrimaryReachable = true
+
+	var rs replSetStatus
+	if err := admin.RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}, primary).Decode(&rs); err != nil {
+		status.LagError = err.Error()
+		for _, host := range hello.Hosts {
+			if host != hello.Primary {
+				status.Secondaries = append(status.Secondaries, host)
+			}
+		}
+		return status, nil
+	}
+
+	var primaryOptime time.Time
+	for _, m := range rs.Members {
+		if m.StateStr == "PRIMARY" {
+			primaryOptime = m.OptimeDate
+		}
+	}
+	readSecondary := f.readsFromSecondary()
+	if readSecondary {
+		status.ReplicationLag = make(map[string]time.Duration)
+	}
+	for _, m := range rs.Members {
+		if m.StateStr != "SECONDARY" || m.Health != 1 {
+			continue
+		}
+		status.Secondaries = append(status.Secondaries, m.Name)
+		if readSecondary && !primaryOptime.IsZero() {
+			lag := primaryOptime.Sub(m.OptimeDate)
+			if lag < 0 {
+				lag = 0
+			}
+			status.ReplicationLag[m.Name] = lag
+		}
+	}
+	sort.Strings(status.Secondaries)
+	return status, nil
+}
+
+// readsFromSecondary 默认的read preference是否可能从secondary读取
+func (f *Client) readsFromSecondary() bool {
+	rp := f.Client().Database("admin").ReadPreference()
+	return rp != nil && rp.Mode() != readpref.PrimaryMode
+}
+
+// HealthHandlerConfig 见NewHealthHandler
+type HealthHandlerConfig struct {
+	// Timeout 每次检查的超时时间，默认3s
+	Timeout time.Duration
+	// MaxRTT 访问primary的耗时超过该值时视为不健康，为0时不检查
+	MaxRTT time.Duration
+	// MaxReplicationLag 任一secondary的复制延迟超过该值时视为不健康，为0时不检查
+	MaxReplicationLag time.Duration
+}
+
+// NewHealthHandler 检查所有client，全部健康时返回200，否则返回503，body为各client的HealthStatus。
+// 可按不同的阈值分别用于/healthz、/readyz，如/healthz只检查primary能否访问，/readyz再检查复制延迟：
+//
+//	mux.Handle("/healthz", gomongodb.NewHealthHandler(gomongodb.HealthHandlerConfig{}, client))
+//	mux.Handle("/readyz", gomongodb.NewHealthHandler(gomongodb.HealthHandlerConfig{MaxReplicationLag: 10 * time.Second}, client))
+func NewHealthHandler(cfg HealthHandlerConfig, clients ...*Client) http.Handler {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 3 * time.Second
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeout)
+		defer cancel()
+
+		healthy := true
+		statuses := make([]*HealthStatus, 0, len(clients))
+		for _, client := range clients {
+			status, err := client.HealthCheck(ctx)
+			if err == nil {
+				err = cfg.check(status)
+				if err != nil {
+					status.Error = err.Error()
+				}
+			}
+			if err != nil {
+				healthy = false
+			}
+			statuses = append(statuses, status)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(statuses)
+	})
+}
+
+func (cfg HealthHandlerConfig) check(status *HealthStatus) error {
+	if cfg.MaxRTT > 0 && status.RTT > cfg.MaxRTT {
+		return fmt.Errorf("rtt %s exceeds %s", status.RTT, cfg.MaxRTT)
+	}
+	if cfg.MaxReplicationLag > 0 {
+		if lag := status.MaxReplicationLag(); lag > cfg.MaxReplicationLag {
+			return fmt.Errorf("replication lag %s exceeds %s", lag, cfg.MaxReplicationLag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package gomongodb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandlerConfig_check(t *testing.T) {
+	status := &HealthStatus{
+		RTT:            5 * time.Millisecond,
+		ReplicationLag: map[string]time.Duration{"a:27017": time.Second, "b:27017": 20 * time.Second},
+	}
+	if lag := status.MaxReplicationLag(); lag != 20*time.Second {
+		t.Errorf("MaxReplicationLag() = %v", lag)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     HealthHandlerConfig
+		wantErr bool
+	}{
+		{"no threshold", HealthHandlerConfig{}, false},
+		{"rtt ok", HealthHandlerConfig{MaxRTT: 10 * time.Millisecond}, false},
+		{"rtt exceeded", HealthHandlerConfig{MaxRTT: time.Millisecond}, true},
+		{"lag ok", HealthHandlerConfig{MaxReplicationLag: 30 * time.Second}, false},
+		{"lag exceeded", HealthHandlerConfig{MaxReplicationLag: 10 * time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.check(status); (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthStatus_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(&HealthStatus{Name: "test", RTT: 1500 * time.Microsecond,
+		ReplicationLag: map[string]time.Duration{"a:27017": 2 * time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); !strings.Contains(s, `"RTT":"1.5ms"`) || !strings.Contains(s, `"ReplicationLag":{"a:27017":"2s"}`) {
+		t.Errorf("MarshalJSON() = %s", s)
+	}
+}
+
+func TestNewHealthHandler(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	client.closed = true
+
+	rec := httptest.NewRecorder()
+	NewHealthHandler(HealthHandlerConfig{}, client).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("code = %d, want 503", rec.Code)
+	}
+	var statuses []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0]["Name"] != "test" || statuses[0]["Error"] != ErrClientClosed.Error() {
+		t.Errorf("body = %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	NewHealthHandler(HealthHandlerConfig{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("code = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,150 @@
+package gomongodb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Operation 描述一次wrapper操作，传递给Interceptor。
+// 修改Filter、Projection、Update、Documents、Pipeline、Models、Sort、Skip、Limit会影响实际执行的语句。
+type Operation struct {
+	Database   string
+	Collection string
+	// Name wrapper的方法名，如Find、UpdateID，同时是metrics的command label
+	Name string
+	// Command mongo的命令名，如find、findAndModify，用于trace
+	Command string
+
+	Filter interface{}
+	// Projection FindProjection、FindOneProjection的projection
+	Projection interface{}
+	// Update update语句，FindOneAndReplace时为replacement
+	Update    interface{}
+	Documents []interface{}
+	Pipeline  interface{}
+	Models    []mongo.WriteModel
+	// Field Distinct的字段名
+	Field string
+	Sort  []string
+	Skip  int64
+	Limit int64
+	// Options 调用方传入的opts，如[]*options.FindOptions，仅供读取
+	Options interface{}
+
+	// Result 接收结果的指针，由invoker填充，interceptor短路返回时可直接写入
+	Result interface{}
+	// DocumentsReturned 返回的文档数，FindOne等方法据此判断has
+	DocumentsReturned int64
+	// DocumentsAffected 插入、修改、删除的文档数，DeleteOne等方法据此判断has
+	DocumentsAffected int64
+	// Attempts 实际执行的次数，发生重试时大于1
+	Attempts int
+
+	// maxTime 本次执行剩余的超时时间，由maxTimeInterceptor在每次执行前设置，作为maxTimeMS发送给服务端
+	maxTime time.Duration
+	// poolFailed 本次执行等待并发令牌失败，没有发送到服务端
+	poolFailed bool
+}
+
+// Invoker 执行操作，由Interceptor调用以进入下一个Interceptor或最终的driver调用
+type Invoker func(ctx context.Context, op *Operation) error
+
+// Interceptor 拦截所有wrapper操作，类似gRPC的UnaryClientInterceptor，可用于鉴权、审计、缓存等。
+// 执行顺序为：metrics、trace、生成builder的语句、client的Interceptor、wrapper的Interceptor、超时、重试、熔断、并发令牌、driver调用。
+type Interceptor func(ctx context.Context, op *Operation, invoker Invoker) error
+
+// AddInterceptor 添加client级别的Interceptor，对该client创建的所有wrapper生效，需在使用wrapper前添加
+func (f *Client) AddInterceptor(interceptors ...Interceptor) {
+	f.interceptors = append(f.interceptors, interceptors...)
+}
+
+func chainInterceptors(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, op *Operation) error {
+			return interceptor(ctx, op, next)
+		}
+	}
+	return invoker
+}
+
+func (c *collectionWrapper) newOperation(name, command string) *Operation {
+	return &Operation{
+		Database:   c.database,
+		Collection: c.collection,
+		Name:       name,
+		Command:    command,
+	}
+}
+
+// invoke 依次经过内置及用户的Interceptor执行操作，返回的错误为*OperationError
+func (c *collectionWrapper) invoke(ctx context.Context, op *Operation, invoker Invoker) error {
+	ctx, done, err := c.client.begin(ctx)
+	if err != nil {
+		return wrapOperationError(op, err)
+	}
+	defer done()
+
+	interceptors := make([]Interceptor, 0, 8+len(c.client.interceptors)+len(c.wrapperOpts.interceptors))
+	interceptors = append(interceptors, c.metricInterceptor, c.traceInterceptor, statementInterceptor)
+	interceptors = append(interceptors, c.client.interceptors...)
+	interceptors = append(interceptors, c.wrapperOpts.interceptors...)
+	interceptors = append(interceptors, c.timeoutInterceptor, c.retryInterceptor, c.breakerInterceptor, c.poolInterceptor, c.maxTimeInterceptor)
+	return wrapOperationError(op, chainInterceptors(interceptors, invoker)(ctx, op))
+}
+
+// operationError 未进入invoke就返回的错误，如参数校验失败，同样包装为*OperationError
+func (c *collectionWrapper) operationError(name string, err error) error {
+	return wrapOperationError(c.newOperation(name, ""), err)
+}
+
+// metricInterceptor 上报metrics及慢日志
+func (c *collectionWrapper) metricInterceptor(ctx context.Context, op *Operation, invoker Invoker) error {
+	st := time.Now()
+	err := invoker(ctx, op)
+	duration := time.Since(st)
+
+	m := OperationMetrics{
+		Target:            c.client.metricTarget,
+		Command:           op.Name,
+		Database:          c.client.convertMetricsLabel(op.Database),
+		Collection:        c.client.convertMetricsLabel(op.Collection),
+		Duration:          duration,
+		Err:               err,
+		ErrorType:         ErrorType(err),
+		DocumentsReturned: op.DocumentsReturned,
+		DocumentsAffected: op.DocumentsAffected,
+	}
+	if op.Attempts > 1 {
+		m.Retries = int64(op.Attempts - 1)
+	}
+	for _, sink := range c.client.metricsSinks {
+		sink.RecordOperation(ctx, m)
+	}
+	c.logSlow(ctx, op, duration, err)
+	return err
+}
+
+func (c *collectionWrapper) traceInterceptor(ctx context.Context, op *Operation, invoker Invoker) (err error) {
+	ctx, span := c.traceMongo(ctx, op)
+	defer func() {
+		c.endTrace(span, err)
+	}()
+	return invoker(ctx, op)
+}
+
+// poolInterceptor 获取client的并发令牌，见Config.Poolsize。Stream、ForEach只在拉取数据期间占用令牌，见fetch
+func (c *collectionWrapper) poolInterceptor(ctx context.Context, op *Operation, invoker Invoker) error {
+	if isStreamOperation(op) {
+		return invoker(ctx, op)
+	}
+	ctx, release, err := c.client.acquire(ctx)
+	if err != nil {
+		op.poolFailed = true
+		return err
+	}
+	defer release()
+	return invoker(ctx, op)
+}
@@ -0,0 +1,102 @@
+package gomongodb
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type recordSink struct {
+	mu      sync.Mutex
+	records []OperationMetrics
+}
+
+func (s *recordSink) RecordOperation(ctx context.Context, m OperationMetrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, m)
+}
+
+// newClientForTest 不建立连接的client，用于在interceptor中短路的场景
+func newClientForTest(sink MetricsSink) *Client {
+	client := &Client{
+		pool:         newTokenPoolForTest(1, time.Millisecond),
+		timeout:      time.Second,
+		metricTarget: "test",
+		metricsSinks: []MetricsSink{sink},
+	}
+	client.conn.Store(&clientConn{})
+	return client
+}
+
+func Test_chainInterceptors(t *testing.T) {
+	var order []string
+	gen := func(name string) Interceptor {
+		return func(ctx context.Context, op *Operation, invoker Invoker) error {
+			order = append(order, name)
+			return invoker(ctx, op)
+		}
+	}
+	invoker := chainInterceptors([]Interceptor{gen("a"), gen("b"), gen("c")}, func(ctx context.Context, op *Operation) error {
+		order = append(order, "invoker")
+		return nil
+	})
+	if err := invoker(context.Background(), &Operation{}); err != nil {
+		t.Fatalf("chainInterceptors() error = %v", err)
+	}
+	if want := []string{"a", "b", "c", "invoker"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("chainInterceptors() order = %v, want %v", order, want)
+	}
+}
+
+func Test_collectionWrapper_interceptorShortCircuit(t *testing.T) {
+	sink := &recordSink{}
+	client := newClientForTest(sink)
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		// 模拟缓存命中，不再访问mongo
+		if op.Name == "FindID" {
+			*op.Result.(*testDataSt) = testDataSt{Likes: 7}
+			op.DocumentsReturned = 1
+			return nil
+		}
+		return invoker(ctx, op)
+	})
+	rejected := errors.New("rejected")
+	wrapper := client.NewCollectionWrapper("db", "col", WithCollectionInterceptors(
+		func(ctx context.Context, op *Operation, invoker Invoker) error {
+			if op.Name == "DeleteMany" {
+				if !reflect.DeepEqual(op.Filter, bson.M{}) {
+					t.Errorf("Operation.Filter = %v, want empty bson.M", op.Filter)
+				}
+				return rejected
+			}
+			return invoker(ctx, op)
+		},
+	))
+
+	var result testDataSt
+	has, err := wrapper.FindID(context.Background(), 1, &result)
+	if err != nil || !has || result.Likes != 7 {
+		t.Errorf("FindID() = %v, %v, %+v, want cached result", has, err, result)
+	}
+
+	_, err = wrapper.DeleteMany(context.Background(), bson.M{})
+	if !errors.Is(err, rejected) {
+		t.Errorf("DeleteMany() error = %v, want %v", err, rejected)
+	}
+
+	if len(sink.records) != 2 {
+		t.Fatalf("metrics records = %d, want 2", len(sink.records))
+	}
+	if r := sink.records[0]; r.Command != "FindID" || r.DocumentsReturned != 1 || r.Err != nil {
+		t.Errorf("metrics record = %+v", r)
+	}
+	if r := sink.records[1]; r.Command != "DeleteMany" || r.Collection != "col" || r.Err != rejected {
+		t.Errorf("metrics record = %+v", r)
+	}
+}
@@ -0,0 +1,220 @@
+package gomongodb
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OperationMetrics 一次wrapper操作的度量，db、collection已经过AddMetricsLabelConverter转换
+type OperationMetrics struct {
+	Target     string
+	Command    string
+	Database   string
+	Collection string
+	Duration   time.Duration
+	Err        error
+	// ErrorType Err的分类，见ErrorType
+	ErrorType string
+
+	// DocumentsReturned 返回给调用方的文档数
+	DocumentsReturned int64
+	// DocumentsAffected 插入、修改、删除的文档数
+	DocumentsAffected int64
+	// Retries 重试的次数，见RetryPolicy
+	Retries int64
+}
+
+// MetricsSink 接收每次wrapper操作的度量，一个client可同时配置多个，见WithMetricsSink、WithMeterProvider
+type MetricsSink interface {
+	RecordOperation(ctx context.Context, m OperationMetrics)
+}
+
+type metricOptions struct {
+	registerer                  prometheus.Registerer
+	namespace                   string
+	subsystem                   string
+	buckets                     []float64
+	nativeHistogramBucketFactor float64
+	constLabels                 prometheus.Labels
+}
+
+// clientMetrics 每个Client独立持有的metrics，相同Registerer下同名的collector会被复用，
+// 直方图的bucket需与已注册的一致，否则返回错误
+type clientMetrics struct {
+	latency     *prometheus.HistogramVec
+	errors      *prometheus.CounterVec
+	poolInUse   *prometheus.GaugeVec
+	poolWaiting *prometheus.GaugeVec
+	returned    *prometheus.CounterVec
+	affected    *prometheus.CounterVec
+	retries     *prometheus.CounterVec
+	// circuitState 0关闭，1半开，2熔断
+	circuitState *prometheus.GaugeVec
+}
+
+var _ MetricsSink = &clientMetrics{}
+
+func newClientMetrics(opt metricOptions) (m *clientMetrics, err error) {
+	m = &clientMetrics{}
+	m.latency, err = registHistogram(opt.registerer, prometheus.HistogramOpts{
+		Namespace:                   opt.namespace,
+		Subsystem:                   opt.subsystem,
+		Name:                        "mongo_official_client_command_latency",
+		Help:                        "Histogram of mongo request",
+		ConstLabels:                 opt.constLabels,
+		Buckets:                     opt.buckets,
+		NativeHistogramBucketFactor: opt.nativeHistogramBucketFactor,
+	}, []string{"target", "command", "db", "collection"})
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.errors, err = registMetrics(opt.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_official_client_command_error",
+		Help:        "Counter of mongo request error",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "command", "db", "collection", "error_type"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.poolInUse, err = registMetrics(opt.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_official_client_pool_in_use",
+		Help:        "Gauge of wrapper operations holding a pool token",
+		ConstLabels: opt.constLabels,
+	}, []string{"target"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.poolWaiting, err = registMetrics(opt.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_official_client_pool_waiting",
+		Help:        "Gauge of wrapper operations waiting for a pool token",
+		ConstLabels: opt.constLabels,
+	}, []string{"target"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.returned, err = registMetrics(opt.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_official_client_documents_returned",
+		Help:        "Counter of documents returned by mongo request",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "command", "db", "collection"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.affected, err = registMetrics(opt.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_official_client_documents_affected",
+		Help:        "Counter of documents inserted, modified or deleted by mongo request",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "command", "db", "collection"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.retries, err = registMetrics(opt.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_official_client_command_retry",
+		Help:        "Counter of mongo request retry",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "command", "db", "collection"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	m.circuitState, err = registMetrics(opt.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opt.namespace,
+		Subsystem:   opt.subsystem,
+		Name:        "mongo_official_client_circuit_state",
+		Help:        "Gauge of circuit breaker state, 0 closed, 1 half-open, 2 open",
+		ConstLabels: opt.constLabels,
+	}, []string{"target", "db", "collection"}))
+	if err != nil {
+		return nil, errors.Wrap(err, "registMetrics")
+	}
+	return m, nil
+}
+
+func (m *clientMetrics) RecordOperation(ctx context.Context, om OperationMetrics) {
+	labels := prometheus.Labels{
+		"target":     om.Target,
+		"command":    om.Command,
+		"db":         om.Database,
+		"collection": om.Collection,
+	}
+	if om.Err != nil {
+		errorType := om.ErrorType
+		if errorType == "" {
+			errorType = ErrorType(om.Err)
+		}
+		m.errors.WithLabelValues(om.Target, om.Command, om.Database, om.Collection, errorType).Add(1)
+	}
+	m.latency.With(labels).Observe(float64(om.Duration.Milliseconds()))
+	if om.DocumentsReturned > 0 {
+		m.returned.With(labels).Add(float64(om.DocumentsReturned))
+	}
+	if om.DocumentsAffected > 0 {
+		m.affected.With(labels).Add(float64(om.DocumentsAffected))
+	}
+	if om.Retries > 0 {
+		m.retries.With(labels).Add(float64(om.Retries))
+	}
+}
+
+func registMetrics[T prometheus.Collector](registerer prometheus.Registerer, collector T) (T, error) {
+	var null T
+	err := registerer.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+
+	if arErr, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		already, ok := arErr.ExistingCollector.(T)
+		if ok {
+			return already, nil
+		} else {
+			return null, errors.Errorf("ExistingCollector, want %v, but %v",
+				reflect.TypeOf(null), reflect.TypeOf(arErr.ExistingCollector))
+		}
+	}
+
+	return null, err
+}
+
+// histogramLayouts 本包注册的直方图的bucket设置，复用已注册的直方图时检查是否一致
+var histogramLayouts sync.Map
+
+type histogramLayout struct {
+	buckets                     []float64
+	nativeHistogramBucketFactor float64
+}
+
+// registHistogram 同registMetrics，复用的直方图的bucket与opts不一致时返回错误，
+// 否则后创建的Client设置的bucket会被静默忽略
+func registHistogram(registerer prometheus.Registerer, opts prometheus.HistogramOpts, labels []string) (*prometheus.HistogramVec, error) {
+	histogram, err := registMetrics(registerer, prometheus.NewHistogramVec(opts, labels))
+	if err != nil {
+		return nil, err
+	}
+	layout := histogramLayout{buckets: opts.Buckets, nativeHistogramBucketFactor: opts.NativeHistogramBucketFactor}
+	if existing, loaded := histogramLayouts.LoadOrStore(histogram, layout); loaded {
+		if e := existing.(histogramLayout); !slices.Equal(e.buckets, layout.buckets) || e.nativeHistogramBucketFactor != layout.nativeHistogramBucketFactor {
+			return nil, errors.Errorf("histogram %s already registered with buckets %v and native factor %v, but got %v and %v",
+				prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+				e.buckets, e.nativeHistogramBucketFactor, layout.buckets, layout.nativeHistogramBucketFactor)
+		}
+	}
+	return histogram, nil
+}
@@ -0,0 +1,218 @@
+package gomongodb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+func Test_newClientMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	opts := defaultClientOptions()
+	WithRegisterer(registry)(&opts)
+	WithMetricNamespace("test")(&opts)
+	WithMetricSubsystem("mongo")(&opts)
+	WithMetricConstLabels(prometheus.Labels{"app": "a"})(&opts)
+
+	m1, err := newClientMetrics(opts.metric)
+	if err != nil {
+		t.Fatalf("newClientMetrics() error = %v", err)
+	}
+	m2, err := newClientMetrics(opts.metric)
+	if err != nil {
+		t.Fatalf("newClientMetrics() again error = %v", err)
+	}
+	if m1.latency != m2.latency || m1.errors != m2.errors {
+		t.Errorf("newClientMetrics() should reuse registered collectors")
+	}
+
+	m1.errors.WithLabelValues("target", "Find", "db", "col", ErrorTypeTimeout).Inc()
+	if n, err := testutil.GatherAndCount(registry, "test_mongo_mongo_official_client_command_error"); err != nil || n != 1 {
+		t.Errorf("GatherAndCount() = %d, %v, want 1", n, err)
+	}
+
+	// const label的值不同，注册为新的series
+	WithMetricConstLabels(prometheus.Labels{"app": "b"})(&opts)
+	if _, err := newClientMetrics(opts.metric); err != nil {
+		t.Errorf("newClientMetrics() other const labels error = %v", err)
+	}
+
+	// 复用已注册的直方图时bucket需一致
+	WithMetricConstLabels(prometheus.Labels{"app": "a"})(&opts)
+	WithLatencyBuckets(1, 10, 100)(&opts)
+	if _, err := newClientMetrics(opts.metric); err == nil {
+		t.Errorf("newClientMetrics() with other buckets want error")
+	}
+	WithNativeHistogram(1.1)(&opts)
+	if _, err := newClientMetrics(opts.metric); err == nil {
+		t.Errorf("newClientMetrics() with native histogram want error")
+	}
+}
+
+func Test_newClientMetrics_conflict(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: DEFAULT_METRIC_NAMESPACE,
+		Name:      "mongo_official_client_command_latency",
+		Help:      "Histogram of mongo request",
+	}, []string{"target", "command", "db", "collection"}))
+
+	opts := defaultClientOptions()
+	WithRegisterer(registry)(&opts)
+	if _, err := newClientMetrics(opts.metric); err == nil {
+		t.Errorf("newClientMetrics() want error when collector type conflicts")
+	}
+}
+
+func Test_newClientMetrics_nativeHistogram(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	opts := defaultClientOptions()
+	WithRegisterer(registry)(&opts)
+	WithNativeHistogram(1.1)(&opts)
+	m, err := newClientMetrics(opts.metric)
+	if err != nil {
+		t.Fatalf("newClientMetrics() error = %v", err)
+	}
+	m.latency.WithLabelValues("target", "Find", "db", "col").Observe(3)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "gomongodb_mongo_official_client_command_latency" {
+			continue
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		if len(h.GetBucket()) != 0 || h.GetSchema() == 0 && h.GetZeroThreshold() == 0 {
+			t.Errorf("latency histogram want native buckets only, got %v", h)
+		}
+	}
+}
+
+func Test_clientMetrics_RecordOperation(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	opts := defaultClientOptions()
+	WithRegisterer(registry)(&opts)
+	m, err := newClientMetrics(opts.metric)
+	if err != nil {
+		t.Fatalf("newClientMetrics() error = %v", err)
+	}
+	m.RecordOperation(context.Background(), OperationMetrics{
+		Target:            "target",
+		Command:           "Find",
+		Database:          "db",
+		Collection:        "col",
+		Duration:          time.Millisecond,
+		Err:               errors.New("err"),
+		DocumentsReturned: 3,
+	})
+	if v := testutil.ToFloat64(m.errors.WithLabelValues("target", "Find", "db", "col", ErrorTypeOther)); v != 1 {
+		t.Errorf("errors = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(m.returned.WithLabelValues("target", "Find", "db", "col")); v != 3 {
+		t.Errorf("returned = %v, want 3", v)
+	}
+	if n := testutil.CollectAndCount(m.affected); n != 0 {
+		t.Errorf("affected series = %v, want 0", n)
+	}
+}
+
+func Test_NewOTelMetricsSink(t *testing.T) {
+	if _, err := NewOTelMetricsSink(noop.NewMeterProvider()); err != nil {
+		t.Fatalf("NewOTelMetricsSink() with noop error = %v", err)
+	}
+
+	reader := sdkmetric.NewManualReader()
+	sink, err := NewOTelMetricsSink(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatalf("NewOTelMetricsSink() error = %v", err)
+	}
+	ctx := context.Background()
+	sink.RecordOperation(ctx, OperationMetrics{Target: "test", Command: "Find", Database: "db", Collection: "col",
+		Duration: time.Millisecond, DocumentsReturned: 2, Retries: 1})
+	sink.RecordOperation(ctx, OperationMetrics{Target: "test", Command: "UpdateOne", Database: "db", Collection: "col",
+		Duration: time.Second, Err: context.DeadlineExceeded, DocumentsAffected: 1})
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 1 || rm.ScopeMetrics[0].Scope.Name != tracerName {
+		t.Fatalf("scope metrics = %+v", rm.ScopeMetrics)
+	}
+	got := map[string]metricdata.Metrics{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		got[m.Name] = m
+	}
+	wantUnits := map[string]string{
+		"db.client.operation.duration":        "s",
+		"gomongodb.client.operation.errors":   "{error}",
+		"gomongodb.client.documents.returned": "{document}",
+		"gomongodb.client.documents.affected": "{document}",
+		"gomongodb.client.operation.retries":  "{retry}",
+	}
+	if len(got) != len(wantUnits) {
+		t.Errorf("metrics = %v, want %v", got, wantUnits)
+	}
+	for name, unit := range wantUnits {
+		if m, ok := got[name]; !ok || m.Unit != unit {
+			t.Errorf("metric %s unit = %q, want %q", name, m.Unit, unit)
+		}
+	}
+
+	attrs := func(operation string, extra ...attribute.KeyValue) attribute.Set {
+		return attribute.NewSet(append([]attribute.KeyValue{
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.namespace", "db"),
+			attribute.String("db.collection.name", "col"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("gomongodb.client", "test"),
+		}, extra...)...)
+	}
+	duration, ok := got["db.client.operation.duration"].Data.(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("duration data = %T, want Histogram[float64]", got["db.client.operation.duration"].Data)
+	}
+	metricdatatest.AssertEqual(t, metricdata.Histogram[float64]{
+		Temporality: metricdata.CumulativeTemporality,
+		DataPoints: []metricdata.HistogramDataPoint[float64]{
+			{Attributes: attrs("Find"), Count: 1, Sum: 0.001},
+			{Attributes: attrs("UpdateOne"), Count: 1, Sum: 1},
+		},
+	}, duration, metricdatatest.IgnoreTimestamp(), metricdatatest.IgnoreValue())
+	for _, dp := range duration.DataPoints {
+		if !reflect.DeepEqual(dp.Bounds, []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}) {
+			t.Errorf("duration bounds = %v", dp.Bounds)
+		}
+	}
+
+	sum := func(name string, dps ...metricdata.DataPoint[int64]) {
+		t.Helper()
+		data, ok := got[name].Data.(metricdata.Sum[int64])
+		if !ok {
+			t.Errorf("metric %s data = %T, want Sum[int64]", name, got[name].Data)
+			return
+		}
+		metricdatatest.AssertEqual(t, metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		}, data, metricdatatest.IgnoreTimestamp())
+	}
+	sum("gomongodb.client.operation.errors", metricdata.DataPoint[int64]{
+		Attributes: attrs("UpdateOne", attribute.String("error.type", ErrorTypeTimeout)), Value: 1})
+	sum("gomongodb.client.documents.returned", metricdata.DataPoint[int64]{Attributes: attrs("Find"), Value: 2})
+	sum("gomongodb.client.documents.affected", metricdata.DataPoint[int64]{Attributes: attrs("UpdateOne"), Value: 1})
+	sum("gomongodb.client.operation.retries", metricdata.DataPoint[int64]{Attributes: attrs("Find"), Value: 1})
+}
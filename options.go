@@ -0,0 +1,184 @@
+package gomongodb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ClientOption 用于InitClientWithOptions，定制Config无法表达的client行为
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	driverOptions []*options.ClientOptions
+	metric        metricOptions
+	hostsAsName   bool
+
+	tracerProvider trace.TracerProvider
+
+	disablePrometheus bool
+	meterProvider     metric.MeterProvider
+	metricsSinks      []MetricsSink
+
+	driverMonitoring bool
+
+	slowLog *SlowLogConfig
+
+	interceptors []Interceptor
+	retryPolicy  *RetryPolicy
+
+	circuitBreaker *CircuitBreakerConfig
+
+	callerDeadline bool
+
+	credentialProvider CredentialProvider
+}
+
+func defaultClientOptions() clientOptions {
+	return clientOptions{
+		metric: metricOptions{
+			registerer: prometheus.DefaultRegisterer,
+			namespace:  DEFAULT_METRIC_NAMESPACE,
+			buckets:    DEFAULT_METRIC_BUCKETS,
+		},
+	}
+}
+
+// WithDriverOptions 追加官方driver的options，与InitClient的opts参数一致，后设置的优先
+func WithDriverOptions(opts ...*options.ClientOptions) ClientOption {
+	return func(o *clientOptions) {
+		o.driverOptions = append(o.driverOptions, opts...)
+	}
+}
+
+// WithRegisterer 指定metrics注册的Registerer，默认为prometheus.DefaultRegisterer
+func WithRegisterer(registerer prometheus.Registerer) ClientOption {
+	return func(o *clientOptions) {
+		o.metric.registerer = registerer
+	}
+}
+
+// WithMetricNamespace 指定metrics的namespace，默认为gomongodb
+func WithMetricNamespace(namespace string) ClientOption {
+	return func(o *clientOptions) {
+		o.metric.namespace = namespace
+	}
+}
+
+// WithMetricSubsystem 指定metrics的subsystem，默认为空
+func WithMetricSubsystem(subsystem string) ClientOption {
+	return func(o *clientOptions) {
+		o.metric.subsystem = subsystem
+	}
+}
+
+// WithLatencyBuckets 指定耗时直方图的bucket，单位毫秒。
+// 同一Registerer下的直方图被所有Client共用，bucket需一致，否则InitClient返回错误
+func WithLatencyBuckets(buckets ...float64) ClientOption {
+	return func(o *clientOptions) {
+		o.metric.buckets = buckets
+	}
+}
+
+// WithNativeHistogram 耗时直方图改为native histogram，factor需大于1。
+// 设置后不再上报经典bucket，需要同时保留时，在其后调用WithLatencyBuckets。
+func WithNativeHistogram(factor float64) ClientOption {
+	return func(o *clientOptions) {
+		o.metric.nativeHistogramBucketFactor = factor
+		o.metric.buckets = nil
+	}
+}
+
+// WithMetricConstLabels 为该client的所有metrics附加固定label
+func WithMetricConstLabels(labels prometheus.Labels) ClientOption {
+	return func(o *clientOptions) {
+		o.metric.constLabels = labels
+	}
+}
+
+// WithHostsAsName Config.Name为空时，使用Config.Hostport中的host列表作为client名称，用户名密码会被去掉
+func WithHostsAsName() ClientOption {
+	return func(o *clientOptions) {
+		o.hostsAsName = true
+	}
+}
+
+// WithTracerProvider 指定trace使用的TracerProvider，默认为otel.GetTracerProvider()
+func WithTracerProvider(tp trace.TracerProvider) ClientOption {
+	return func(o *clientOptions) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider 同时将操作度量输出到OpenTelemetry，可与Prometheus并存
+func WithMeterProvider(mp metric.MeterProvider) ClientOption {
+	return func(o *clientOptions) {
+		o.meterProvider = mp
+	}
+}
+
+// WithMetricsSink 追加自定义的MetricsSink
+func WithMetricsSink(sink MetricsSink) ClientOption {
+	return func(o *clientOptions) {
+		o.metricsSinks = append(o.metricsSinks, sink)
+	}
+}
+
+// WithoutPrometheus 不再向Prometheus上报，metrics注册到一个不对外暴露的Registry中
+func WithoutPrometheus() ClientOption {
+	return func(o *clientOptions) {
+		o.disablePrometheus = true
+	}
+}
+
+// WithDriverMonitoring 在driver上安装command、pool、server monitor，上报driver层面的Prometheus metrics，
+// 包括命令耗时、连接获取等待、连接池大小及使用数、连接错误、心跳RTT，与wrapper使用相同的target及label转换
+func WithDriverMonitoring() ClientOption {
+	return func(o *clientOptions) {
+		o.driverMonitoring = true
+	}
+}
+
+// WithSlowLog 开启慢操作日志，记录db、collection、方法名、脱敏后的filter/sort/projection、skip/limit、耗时及错误
+func WithSlowLog(cfg SlowLogConfig) ClientOption {
+	return func(o *clientOptions) {
+		o.slowLog = &cfg
+	}
+}
+
+// WithInterceptors 添加client级别的Interceptor，见Client.AddInterceptor
+func WithInterceptors(interceptors ...Interceptor) ClientOption {
+	return func(o *clientOptions) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// WithRetryPolicy 开启client级别的重试，见RetryPolicy
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(o *clientOptions) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithCircuitBreaker 开启熔断，按collection统计错误率及连续超时，熔断期间操作直接返回ErrCircuitOpen，见CircuitBreakerConfig
+func WithCircuitBreaker(cfg CircuitBreakerConfig) ClientOption {
+	cfg = cfg.withDefaults()
+	return func(o *clientOptions) {
+		o.circuitBreaker = &cfg
+	}
+}
+
+// WithCallerDeadline ctx已有deadline时直接使用，不再按Config中的超时时间覆盖，ctx没有deadline时仍使用Config的超时
+func WithCallerDeadline() ClientOption {
+	return func(o *clientOptions) {
+		o.callerDeadline = true
+	}
+}
+
+// WithCredentialProvider 从CredentialProvider获取用户名及密码，凭证变化时自动使用新凭证重新连接，见CredentialProvider
+func WithCredentialProvider(provider CredentialProvider) ClientOption {
+	return func(o *clientOptions) {
+		o.credentialProvider = provider
+	}
+}
@@ -0,0 +1,88 @@
+package gomongodb
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// otelMetricsSink 将wrapper操作的度量输出到OpenTelemetry的MeterProvider
+type otelMetricsSink struct {
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+	returned metric.Int64Counter
+	affected metric.Int64Counter
+	retries  metric.Int64Counter
+}
+
+var _ MetricsSink = &otelMetricsSink{}
+
+// NewOTelMetricsSink 基于MeterProvider创建MetricsSink，耗时单位为秒，符合OpenTelemetry数据库语义约定
+func NewOTelMetricsSink(mp metric.MeterProvider) (MetricsSink, error) {
+	meter := mp.Meter(tracerName)
+	s := &otelMetricsSink{}
+	var err error
+	s.duration, err = meter.Float64Histogram("db.client.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of database client operations"),
+		metric.WithExplicitBucketBoundaries(0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10))
+	if err != nil {
+		return nil, errors.Wrap(err, "Float64Histogram")
+	}
+	s.errors, err = meter.Int64Counter("gomongodb.client.operation.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Number of failed database client operations"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Int64Counter")
+	}
+	s.returned, err = meter.Int64Counter("gomongodb.client.documents.returned",
+		metric.WithUnit("{document}"),
+		metric.WithDescription("Number of documents returned by database client operations"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Int64Counter")
+	}
+	s.affected, err = meter.Int64Counter("gomongodb.client.documents.affected",
+		metric.WithUnit("{document}"),
+		metric.WithDescription("Number of documents inserted, modified or deleted by database client operations"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Int64Counter")
+	}
+	s.retries, err = meter.Int64Counter("gomongodb.client.operation.retries",
+		metric.WithUnit("{retry}"),
+		metric.WithDescription("Number of retries of database client operations"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Int64Counter")
+	}
+	return s, nil
+}
+
+func (s *otelMetricsSink) RecordOperation(ctx context.Context, m OperationMetrics) {
+	kvs := []attribute.KeyValue{
+		semconv.DBSystemMongoDB,
+		semconv.DBNamespace(m.Database),
+		semconv.DBCollectionName(m.Collection),
+		semconv.DBOperationName(m.Command),
+		attribute.String("gomongodb.client", m.Target),
+	}
+	attrs := metric.WithAttributeSet(attribute.NewSet(kvs...))
+	s.duration.Record(ctx, m.Duration.Seconds(), attrs)
+	if m.Err != nil {
+		errorType := m.ErrorType
+		if errorType == "" {
+			errorType = ErrorType(m.Err)
+		}
+		s.errors.Add(ctx, 1, metric.WithAttributeSet(attribute.NewSet(append(kvs, semconv.ErrorTypeKey.String(errorType))...)))
+	}
+	if m.DocumentsReturned > 0 {
+		s.returned.Add(ctx, m.DocumentsReturned, attrs)
+	}
+	if m.DocumentsAffected > 0 {
+		s.affected.Add(ctx, m.DocumentsAffected, attrs)
+	}
+	if m.Retries > 0 {
+		s.retries.Add(ctx, m.Retries, attrs)
+	}
+}
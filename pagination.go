@@ -0,0 +1,315 @@
+package gomongodb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidPageToken 分页token无法解析、签名错误，或filter、sort与生成token时不同
+var ErrInvalidPageToken = errors.New("gomongodb: invalid page token")
+
+// Page Paginate返回的一页数据
+type Page[T any] struct {
+	Items []T
+	// NextToken 下一页的token，没有下一页时为空
+	NextToken string
+	// PrevToken 上一页的token，没有上一页时为空
+	PrevToken string
+}
+
+// pageToken 分页token的内容，Values为边界文档的排序字段的值
+type pageToken struct {
+	Backward bool            `bson:"b,omitempty"`
+	Values   []bson.RawValue `bson:"v"`
+	Hash     []byte          `bson:"h"`
+}
+
+/*
+Paginate 基于排序字段的值分页(keyset pagination)，翻页的耗时与页数无关，不会像skip一样扫描并丢弃前面的文档。
+
+sort的格式同GenSortBson，末尾自动追加_id保证顺序唯一，应有对应的索引。
+token为空时返回第一页，之后传入返回的NextToken、PrevToken向后、向前翻页。
+filter、sort需与生成token时相同，否则返回ErrInvalidPageToken。
+token默认不签名，见WithPageTokenKey。
+排序字段缺失或为null的文档无法被正确分页。
+*/
+func (c *collectionWrapperGeneric[T]) Paginate(ctx context.Context, filter interface{}, sort []string,
+	limit int64, token string, opts ...*options.FindOptions) (page *Page[T], err error) {
+
+	if limit <= 0 {
+		return nil, c.operationError("Paginate", errors.New("gomongodb: Paginate limit must be positive"))
+	}
+	filter = typedFilter[T](filter)
+	sortD := paginationSort(c.GenSortBson(sort))
+	var cursor *pageToken
+	querySort := sortD
+	if token != "" {
+		if cursor, err = c.decodePageToken(token, len(sortD)); err != nil {
+			return nil, c.operationError("Paginate", err)
+		}
+		if cursor.Backward {
+			querySort = invertSort(sortD)
+		}
+	}
+
+	var raws []bson.Raw
+	op := c.newOperation("Paginate", "find")
+	query := &paginationQuery{sort: sortD, token: cursor}
+	if b, ok := filter.(statementBuilder); ok {
+		// 在interceptor链中生成，与其他操作一致
+		op.Filter = statementFunc(func() (bson.D, error) {
+			filter, err := b.Build()
+			if err != nil {
+				return nil, err
+			}
+			query, err := query.resolve(filter)
+			if err != nil {
+				return nil, err
+			}
+			return query.(bson.D), nil
+		})
+	} else if op.Filter, err = query.resolve(filter); err != nil {
+		return nil, c.operationError("Paginate", err)
+	}
+	op.Sort, op.Limit, op.Options, op.Result = sortStrings(querySort), limit+1, opts, &raws
+	if err = c.invoke(ctx, op, c.findInvoker(opts)); err != nil {
+		return nil, err
+	}
+	hash := query.hash
+
+	more := int64(len(raws)) > limit
+	if more {
+		raws = raws[:limit]
+	}
+	backward := cursor != nil && cursor.Backward
+	if backward {
+		for i, j := 0, len(raws)-1; i < j; i, j = i+1, j-1 {
+			raws[i], raws[j] = raws[j], raws[i]
+		}
+	}
+
+	page = &Page[T]{Items: make([]T, len(raws))}
+	for i, raw := range raws {
+		if err = bson.Unmarshal(raw, &page.Items[i]); err != nil {
+			return nil, wrapOperationError(op, errors.Wrap(err, "Unmarshal"))
+		}
+	}
+	if len(raws) == 0 {
+		return page, nil
+	}
+	first, last := raws[0], raws[len(raws)-1]
+	// 向前翻页时，当前页之后一定还有数据；向后翻页时，当前页之前一定还有数据
+	if backward && more || !backward && cursor != nil {
+		if page.PrevToken, err = c.encodePageToken(first, sortD, hash, true); err != nil {
+			return nil, wrapOperationError(op, err)
+		}
+	}
+	if !backward && more || backward {
+		if page.NextToken, err = c.encodePageToken(last, sortD, hash, false); err != nil {
+			return nil, wrapOperationError(op, err)
+		}
+	}
+	return page, nil
+}
+
+// paginationQuery Paginate执行的filter，即filter及token的keyset条件，hash在resolve时计算
+type paginationQuery struct {
+	sort  bson.D
+	token *pageToken
+	hash  []byte
+}
+
+// resolve 校验token与filter、sort一致，返回加上keyset条件的filter
+func (q *paginationQuery) resolve(filter interface{}) (interface{}, error) {
+	hash, err := paginationHash(filter, q.sort)
+	if err != nil {
+		return nil, err
+	}
+	q.hash = hash
+	if q.token == nil {
+		return filter, nil
+	}
+	if !hmac.Equal(q.token.Hash, hash) {
+		return nil, errors.Wrap(ErrInvalidPageToken, "filter or sort changed")
+	}
+	return andFilter(filter, keysetFilter(q.sort, q.token.Values, q.token.Backward)), nil
+}
+
+// paginationSort sort中没有_id时追加_id升序
+func paginationSort(sortD bson.D) bson.D {
+	for _, e := range sortD {
+		if e.Key == "_id" {
+			return sortD
+		}
+	}
+	return append(sortD, bson.E{Key: "_id", Value: 1})
+}
+
+func invertSort(sortD bson.D) bson.D {
+	inverted := make(bson.D, len(sortD))
+	for i, e := range sortD {
+		inverted[i] = bson.E{Key: e.Key, Value: -sortDirection(e.Value)}
+	}
+	return inverted
+}
+
+// sortDirection 排序方向，1升序，-1降序，支持int、int32、int64及浮点数，其他类型按升序
+func sortDirection(v interface{}) int {
+	var f float64
+	switch v := v.(type) {
+	case int:
+		f = float64(v)
+	case int32:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case float32:
+		f = float64(v)
+	case float64:
+		f = v
+	}
+	if f < 0 {
+		return -1
+	}
+	return 1
+}
+
+// sortStrings 转换回GenSortBson的格式
+func sortStrings(sortD bson.D) []string {
+	result := make([]string, len(sortD))
+	for i, e := range sortD {
+		if sortDirection(e.Value) < 0 {
+			result[i] = "-" + e.Key
+		} else {
+			result[i] = e.Key
+		}
+	}
+	return result
+}
+
+// keysetFilter 排在边界文档之后的文档，如sort为a、b时：{$or: [{a: {$gt: va}}, {a: va, b: {$gt: vb}}]}
+func keysetFilter(sortD bson.D, values []bson.RawValue, backward bool) bson.D {
+	or := make(bson.A, 0, len(sortD))
+	for i, e := range sortD {
+		cond := make(bson.D, 0, i+1)
+		for j := 0; j < i; j++ {
+			cond = append(cond, bson.E{Key: sortD[j].Key, Value: values[j]})
+		}
+		op := "$gt"
+		if (sortDirection(e.Value) > 0) == backward {
+			op = "$lt"
+		}
+		cond = append(cond, bson.E{Key: e.Key, Value: bson.D{{Key: op, Value: values[i]}}})
+		or = append(or, cond)
+	}
+	return bson.D{{Key: "$or", Value: or}}
+}
+
+func andFilter(filter interface{}, cond bson.D) interface{} {
+	if filter == nil {
+		return cond
+	}
+	return bson.D{{Key: "$and", Value: bson.A{filter, cond}}}
+}
+
+// paginationHash filter及sort的摘要，bson.M的字段顺序不固定，按字段名排序后计算
+func paginationHash(filter interface{}, sortD bson.D) ([]byte, error) {
+	h := sha256.New()
+	if filter != nil {
+		t, data, err := bson.MarshalValue(filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal filter")
+		}
+		var buf bytes.Buffer
+		writeCanonicalValue(&buf, bson.RawValue{Type: t, Value: data})
+		h.Write(buf.Bytes())
+	}
+	h.Write([]byte(strings.Join(sortStrings(sortD), ",")))
+	return h.Sum(nil)[:16], nil
+}
+
+func writeCanonicalValue(buf *bytes.Buffer, v bson.RawValue) {
+	buf.WriteByte(byte(v.Type))
+	switch v.Type {
+	case bsontype.EmbeddedDocument:
+		elems, _ := v.Document().Elements()
+		sort.Slice(elems, func(i, j int) bool {
+			return elems[i].Key() < elems[j].Key()
+		})
+		for _, elem := range elems {
+			buf.WriteString(elem.Key())
+			buf.WriteByte(0)
+			writeCanonicalValue(buf, elem.Value())
+		}
+		buf.WriteByte(0)
+	case bsontype.Array:
+		values, _ := v.Array().Values()
+		for _, value := range values {
+			writeCanonicalValue(buf, value)
+		}
+		buf.WriteByte(0)
+	default:
+		buf.Write(v.Value)
+	}
+}
+
+func (c *collectionWrapperGeneric[T]) encodePageToken(doc bson.Raw, sortD bson.D, hash []byte, backward bool) (string, error) {
+	token := pageToken{Backward: backward, Values: make([]bson.RawValue, len(sortD)), Hash: hash}
+	for i, e := range sortD {
+		v, err := doc.LookupErr(strings.Split(e.Key, ".")...)
+		if err != nil {
+			v = bson.RawValue{Type: bsontype.Null}
+		}
+		token.Values[i] = v
+	}
+	payload, err := bson.Marshal(token)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal page token")
+	}
+	s := base64.RawURLEncoding.EncodeToString(payload)
+	if key := c.wrapperOpts.pageTokenKey; len(key) > 0 {
+		s += "." + base64.RawURLEncoding.EncodeToString(signPageToken(key, payload))
+	}
+	return s, nil
+}
+
+// decodePageToken 解析token并校验签名，与filter是否一致由paginationQuery校验
+func (c *collectionWrapperGeneric[T]) decodePageToken(s string, sortLen int) (*pageToken, error) {
+	encoded, sig, signed := strings.Cut(s, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPageToken, "decode")
+	}
+	if key := c.wrapperOpts.pageTokenKey; len(key) > 0 {
+		mac, err := base64.RawURLEncoding.DecodeString(sig)
+		if !signed || err != nil || !hmac.Equal(mac, signPageToken(key, payload)) {
+			return nil, errors.Wrap(ErrInvalidPageToken, "signature mismatch")
+		}
+	} else if signed {
+		return nil, errors.Wrap(ErrInvalidPageToken, "unexpected signature")
+	}
+	var token pageToken
+	if err := bson.Unmarshal(payload, &token); err != nil {
+		return nil, errors.Wrap(ErrInvalidPageToken, "unmarshal")
+	}
+	if len(token.Values) != sortLen {
+		return nil, errors.Wrap(ErrInvalidPageToken, "sort changed")
+	}
+	return &token, nil
+}
+
+func signPageToken(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
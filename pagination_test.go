@@ -0,0 +1,244 @@
+package gomongodb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type pageDoc struct {
+	ID    int   `bson:"_id"`
+	Likes int64 `bson:"likes"`
+}
+
+func pageRaws(t *testing.T, docs ...pageDoc) []bson.Raw {
+	raws := make([]bson.Raw, len(docs))
+	for i, doc := range docs {
+		b, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raws[i] = b
+	}
+	return raws
+}
+
+func Test_Paginate(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	var last *Operation
+	var reply []bson.Raw
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		last = op
+		*op.Result.(*[]bson.Raw) = reply
+		return nil
+	})
+	wrapper := NewCollectionWrapper[pageDoc](client, "db", "col")
+	ctx := context.Background()
+	filter := bson.M{"likes": bson.M{"$gt": 0}}
+
+	// 第一页，多取一条判断是否有下一页
+	reply = pageRaws(t, pageDoc{1, 9}, pageDoc{2, 8}, pageDoc{3, 8})
+	page, err := wrapper.Paginate(ctx, filter, []string{"-likes"}, 2, "")
+	if err != nil {
+		t.Fatalf("Paginate() error = %v", err)
+	}
+	if !reflect.DeepEqual(page.Items, []pageDoc{{1, 9}, {2, 8}}) || page.NextToken == "" || page.PrevToken != "" {
+		t.Fatalf("Paginate() = %+v", page)
+	}
+	if !reflect.DeepEqual(last.Sort, []string{"-likes", "_id"}) || last.Limit != 3 || !reflect.DeepEqual(last.Filter, filter) {
+		t.Errorf("Operation = %+v", last)
+	}
+
+	// 下一页，从{_id: 2, likes: 8}之后开始
+	reply = pageRaws(t, pageDoc{3, 8}, pageDoc{4, 7})
+	page, err = wrapper.Paginate(ctx, bson.M{"likes": bson.M{"$gt": 0}}, []string{"-likes"}, 2, page.NextToken)
+	if err != nil {
+		t.Fatalf("Paginate() error = %v", err)
+	}
+	if !reflect.DeepEqual(page.Items, []pageDoc{{3, 8}, {4, 7}}) || page.NextToken != "" || page.PrevToken == "" {
+		t.Fatalf("Paginate() = %+v", page)
+	}
+	keyset := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "likes", Value: bson.D{{Key: "$lt", Value: bson.RawValue{}}}}},
+		bson.D{{Key: "likes", Value: bson.RawValue{}}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: bson.RawValue{}}}}},
+	}}}
+	got := last.Filter.(bson.D)[0].Value.(bson.A)[1].(bson.D)
+	if !sameKeyset(got, keyset) {
+		t.Errorf("keyset filter = %v", got)
+	}
+	if v := got[0].Value.(bson.A)[1].(bson.D)[0].Value.(bson.RawValue); v.Int64() != 8 {
+		t.Errorf("keyset value = %v, want 8", v)
+	}
+
+	// 上一页，按相反的顺序查询后倒序
+	reply = pageRaws(t, pageDoc{2, 8}, pageDoc{1, 9})
+	page, err = wrapper.Paginate(ctx, filter, []string{"-likes"}, 2, page.PrevToken)
+	if err != nil {
+		t.Fatalf("Paginate() error = %v", err)
+	}
+	if !reflect.DeepEqual(page.Items, []pageDoc{{1, 9}, {2, 8}}) || page.NextToken == "" || page.PrevToken != "" {
+		t.Fatalf("Paginate() = %+v", page)
+	}
+	if !reflect.DeepEqual(last.Sort, []string{"likes", "-_id"}) {
+		t.Errorf("Operation.Sort = %v", last.Sort)
+	}
+
+	// filter或sort变化
+	if _, err := wrapper.Paginate(ctx, bson.M{"likes": bson.M{"$gt": 1}}, []string{"-likes"}, 2, page.NextToken); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("Paginate() with changed filter error = %v", err)
+	}
+	if _, err := wrapper.Paginate(ctx, filter, []string{"likes"}, 2, page.NextToken); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("Paginate() with changed sort error = %v", err)
+	}
+	var oe *OperationError
+	if _, err := wrapper.Paginate(ctx, filter, []string{"-likes"}, 2, "!"); !errors.Is(err, ErrInvalidPageToken) || !errors.As(err, &oe) {
+		t.Errorf("Paginate() with bad token error = %v", err)
+	}
+	if _, err := wrapper.Paginate(ctx, filter, []string{"-likes"}, 0, ""); !errors.As(err, &oe) || oe.Operation != "Paginate" {
+		t.Errorf("Paginate() with limit 0 error = %v, want *OperationError", err)
+	}
+}
+
+func Test_PaginateBuilder(t *testing.T) {
+	sink := &recordSink{}
+	client := newClientForTest(sink)
+	var last *Operation
+	var reply []bson.Raw
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		last = op
+		*op.Result.(*[]bson.Raw) = reply
+		return nil
+	})
+	wrapper := NewCollectionWrapper[pageDoc](client, "db", "col")
+	ctx := context.Background()
+
+	// builder在interceptor之前生成，token与等价的bson filter通用
+	reply = pageRaws(t, pageDoc{1, 9}, pageDoc{2, 8}, pageDoc{3, 8})
+	page, err := wrapper.Paginate(ctx, builder.NewFilter[pageDoc]().Gt("likes", 0), []string{"-likes"}, 2, "")
+	if err != nil {
+		t.Fatalf("Paginate() error = %v", err)
+	}
+	want := bson.D{{Key: "likes", Value: bson.D{{Key: "$gt", Value: 0}}}}
+	if !reflect.DeepEqual(last.Filter, want) {
+		t.Errorf("Operation.Filter = %v, want %v", last.Filter, want)
+	}
+	reply = pageRaws(t, pageDoc{3, 8})
+	if _, err = wrapper.Paginate(ctx, builder.NewFilter[pageDoc]().Gt("likes", 0), []string{"-likes"}, 2, page.NextToken); err != nil {
+		t.Fatalf("Paginate() next page error = %v", err)
+	}
+	if and, ok := last.Filter.(bson.D); !ok || and[0].Key != "$and" || !reflect.DeepEqual(and[0].Value.(bson.A)[0], want) {
+		t.Errorf("Operation.Filter = %v", last.Filter)
+	}
+	if _, err = wrapper.Paginate(ctx, builder.NewFilter[pageDoc]().Gt("likes", 1), []string{"-likes"}, 2, page.NextToken); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("Paginate() with changed filter error = %v", err)
+	}
+
+	// 生成失败同样记录metrics，不执行interceptor
+	last, sink.records = nil, nil
+	_, err = wrapper.Paginate(ctx, builder.NewFilter[pageDoc]().Gt("lieks", 0), []string{"-likes"}, 2, "")
+	if !errors.Is(err, builder.ErrUnknownField) || last != nil {
+		t.Errorf("Paginate() error = %v, interceptor called: %v", err, last != nil)
+	}
+	if len(sink.records) != 1 || sink.records[0].Command != "Paginate" || sink.records[0].ErrorType != ErrorTypeValidation {
+		t.Errorf("metrics records = %+v", sink.records)
+	}
+}
+
+func sameKeyset(got, want bson.D) bool {
+	if len(got) != 1 || got[0].Key != want[0].Key {
+		return false
+	}
+	gotOr, wantOr := got[0].Value.(bson.A), want[0].Value.(bson.A)
+	if len(gotOr) != len(wantOr) {
+		return false
+	}
+	for i := range gotOr {
+		g, w := gotOr[i].(bson.D), wantOr[i].(bson.D)
+		if len(g) != len(w) {
+			return false
+		}
+		for j := range g {
+			if g[j].Key != w[j].Key {
+				return false
+			}
+			if wd, ok := w[j].Value.(bson.D); ok {
+				if gd, ok := g[j].Value.(bson.D); !ok || gd[0].Key != wd[0].Key {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func Test_PaginateSigned(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		*op.Result.(*[]bson.Raw) = pageRaws(t, pageDoc{1, 9}, pageDoc{2, 8})
+		return nil
+	})
+	signed := NewCollectionWrapper[pageDoc](client, "db", "col", WithPageTokenKey([]byte("secret")))
+	unsigned := NewCollectionWrapper[pageDoc](client, "db", "col")
+	other := NewCollectionWrapper[pageDoc](client, "db", "col", WithPageTokenKey([]byte("other")))
+	ctx := context.Background()
+
+	page, err := signed.Paginate(ctx, nil, nil, 1, "")
+	if err != nil || page.NextToken == "" {
+		t.Fatalf("Paginate() = %+v, %v", page, err)
+	}
+	if _, err := signed.Paginate(ctx, nil, nil, 1, page.NextToken); err != nil {
+		t.Errorf("Paginate() with signed token error = %v", err)
+	}
+	for name, wrapper := range map[string]CollectionWrapperGeneric[pageDoc]{"unsigned": unsigned, "other key": other} {
+		if _, err := wrapper.Paginate(ctx, nil, nil, 1, page.NextToken); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("%s Paginate() error = %v, want ErrInvalidPageToken", name, err)
+		}
+	}
+
+	unsignedPage, err := unsigned.Paginate(ctx, nil, nil, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signed.Paginate(ctx, nil, nil, 1, unsignedPage.NextToken); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("Paginate() with unsigned token error = %v, want ErrInvalidPageToken", err)
+	}
+}
+
+func Test_paginationHash(t *testing.T) {
+	sortD := paginationSort(bson.D{{Key: "likes", Value: -1}})
+	a, err := paginationHash(bson.M{"a": 1, "b": bson.M{"c": 2, "d": 3}, "e": 4}, sortD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bson.M的字段顺序不固定
+	for i := 0; i < 10; i++ {
+		b, _ := paginationHash(bson.M{"e": 4, "b": bson.M{"d": 3, "c": 2}, "a": 1}, sortD)
+		if !reflect.DeepEqual(a, b) {
+			t.Fatalf("paginationHash() not stable")
+		}
+	}
+	if b, _ := paginationHash(bson.M{"a": 1, "b": bson.M{"c": 2, "d": 3}, "e": 5}, sortD); reflect.DeepEqual(a, b) {
+		t.Errorf("paginationHash() should differ")
+	}
+}
+
+func Test_sortDirection(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		want  int
+	}{
+		{1, 1}, {-1, -1}, {int32(-1), -1}, {int64(1), 1}, {float64(-1), -1}, {float32(1), 1}, {"desc", 1},
+	} {
+		if got := sortDirection(tt.value); got != tt.want {
+			t.Errorf("sortDirection(%#v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+	sortD := bson.D{{Key: "a", Value: int64(-1)}, {Key: "_id", Value: float64(1)}}
+	if got := sortStrings(invertSort(sortD)); !reflect.DeepEqual(got, []string{"a", "-_id"}) {
+		t.Errorf("sortStrings(invertSort()) = %v", got)
+	}
+}
@@ -0,0 +1,89 @@
+package gomongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrPoolExhausted 等待并发令牌超时，可通过errors.Is(err, ErrPoolExhausted)判断
+var ErrPoolExhausted = errors.New("gomongodb: pool exhausted")
+
+// PoolExhaustedError 等待并发令牌超时的详细信息
+type PoolExhaustedError struct {
+	Target   string
+	Poolsize int
+	Waited   time.Duration
+}
+
+func (e *PoolExhaustedError) Error() string {
+	return fmt.Sprintf("gomongodb: pool exhausted, target %s, poolsize %d, waited %s", e.Target, e.Poolsize, e.Waited)
+}
+
+func (e *PoolExhaustedError) Is(target error) bool {
+	return target == ErrPoolExhausted
+}
+
+type ctxKeyPoolToken struct{}
+
+// tokenPool 客户端侧的并发限制，每个wrapper操作都需要先拿到一个令牌，防止单个热点接口占满driver的连接池
+type tokenPool struct {
+	tokens      chan bool
+	waitTimeout time.Duration
+	inUse       prometheus.Gauge
+	waiting     prometheus.Gauge
+}
+
+func newTokenPool(size int, waitTimeout time.Duration, inUse, waiting prometheus.Gauge) *tokenPool {
+	tokens := make(chan bool, size)
+	for i := 0; i < size; i++ {
+		tokens <- true
+	}
+	return &tokenPool{
+		tokens:      tokens,
+		waitTimeout: waitTimeout,
+		inUse:       inUse,
+		waiting:     waiting,
+	}
+}
+
+// acquire 获取一个令牌，返回的release必需调用。
+// 返回的ctx带有已持有令牌的标记，UseSession等闭包中的嵌套调用不会重复获取，避免poolsize较小时死锁。
+func (p *tokenPool) acquire(ctx context.Context, target string) (context.Context, func(), error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Value(ctxKeyPoolToken{}) == p {
+		return ctx, func() {}, nil
+	}
+
+	select {
+	case <-p.tokens:
+	default:
+		// 没有空闲令牌时才进入等待
+		st := time.Now()
+		p.waiting.Inc()
+		timer := time.NewTimer(p.waitTimeout)
+		defer timer.Stop()
+		select {
+		case <-p.tokens:
+			p.waiting.Dec()
+		case <-timer.C:
+			p.waiting.Dec()
+			return ctx, nil, &PoolExhaustedError{Target: target, Poolsize: cap(p.tokens), Waited: time.Since(st)}
+		case <-ctx.Done():
+			p.waiting.Dec()
+			return ctx, nil, errors.Wrap(ctx.Err(), "wait pool")
+		}
+	}
+
+	p.inUse.Inc()
+	release := func() {
+		p.inUse.Dec()
+		p.tokens <- true
+	}
+	return context.WithValue(ctx, ctxKeyPoolToken{}, p), release, nil
+}
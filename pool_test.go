@@ -0,0 +1,82 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTokenPoolForTest(size int, waitTimeout time.Duration) *tokenPool {
+	return newTokenPool(size, waitTimeout,
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_use"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "waiting"}))
+}
+
+func Test_tokenPool_acquire(t *testing.T) {
+	p := newTokenPoolForTest(1, 20*time.Millisecond)
+
+	ctx, release, err := p.acquire(nil, "test")
+	if err != nil {
+		t.Fatalf("tokenPool.acquire() error = %v", err)
+	}
+	if v := testutil.ToFloat64(p.inUse); v != 1 {
+		t.Errorf("tokenPool.inUse = %v, want 1", v)
+	}
+
+	// 持有令牌的ctx重入时不再获取
+	_, releaseNested, err := p.acquire(ctx, "test")
+	if err != nil {
+		t.Errorf("tokenPool.acquire() nested error = %v", err)
+	}
+	releaseNested()
+
+	_, _, err = p.acquire(context.Background(), "test")
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("tokenPool.acquire() error = %v, want ErrPoolExhausted", err)
+	}
+	var exhausted *PoolExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Poolsize != 1 || exhausted.Target != "test" {
+		t.Errorf("tokenPool.acquire() error = %#v, want PoolExhaustedError", err)
+	}
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = p.acquire(cancelCtx, "test")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("tokenPool.acquire() error = %v, want context.Canceled", err)
+	}
+	if v := testutil.ToFloat64(p.waiting); v != 0 {
+		t.Errorf("tokenPool.waiting = %v, want 0", v)
+	}
+
+	release()
+	if v := testutil.ToFloat64(p.inUse); v != 0 {
+		t.Errorf("tokenPool.inUse = %v, want 0", v)
+	}
+	_, release, err = p.acquire(context.Background(), "test")
+	if err != nil {
+		t.Errorf("tokenPool.acquire() after release error = %v", err)
+	}
+	release()
+}
+
+func Test_tokenPool_wait(t *testing.T) {
+	p := newTokenPoolForTest(1, time.Second)
+	_, release, err := p.acquire(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("tokenPool.acquire() error = %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release()
+	}()
+	_, release, err = p.acquire(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("tokenPool.acquire() waiting error = %v", err)
+	}
+	release()
+}
@@ -0,0 +1,154 @@
+package gomongodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRegistryClosed Registry已关闭
+var ErrRegistryClosed = errors.New("gomongodb: registry closed")
+
+// Registry 按名称管理多个集群的Client，Client在第一次使用时才初始化，名称同时作为metrics的target label
+type Registry struct {
+	configs map[string]Config
+	opts    []ClientOption
+
+	mu      sync.RWMutex
+	closed  bool
+	entries map[string]*registryEntry
+}
+
+type registryEntry struct {
+	mu     sync.Mutex // 串行化初始化
+	client atomic.Pointer[Client]
+}
+
+// NewRegistry configs可直接从yaml、json、ini中解析，opts作用于所有Client。
+// 所有Config会先通过Validate校验，返回的error包含每个Config的全部问题。
+func NewRegistry(configs map[string]Config, opts ...ClientOption) (*Registry, error) {
+	r := &Registry{
+		configs: make(map[string]Config, len(configs)),
+		opts:    opts,
+		entries: make(map[string]*registryEntry, len(configs)),
+	}
+	clientOpts := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&clientOpts)
+	}
+	var problems []string
+	for _, name := range sortedNames(configs) {
+		cfg := configs[name]
+		cfg.Name = name
+		if err := cfg.Validate(clientOpts.driverOptions...); err != nil {
+			var ce *ConfigError
+			if errors.As(err, &ce) {
+				for _, p := range ce.Problems {
+					problems = append(problems, fmt.Sprintf("%s: %s", name, p))
+				}
+			} else {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+		r.configs[name] = cfg
+		r.entries[name] = &registryEntry{}
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	return r, nil
+}
+
+// Names 所有Client的名称
+func (r *Registry) Names() []string {
+	return sortedNames(r.configs)
+}
+
+// Client 获取名称对应的Client，未初始化时进行初始化，初始化失败时下次调用会重新尝试
+func (r *Registry) Client(name string) (*Client, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	entry, ok := r.entries[name]
+	if !ok {
+		return nil, errors.Errorf("gomongodb: unknown client %q", name)
+	}
+
+	if client := entry.client.Load(); client != nil {
+		return client, nil
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if client := entry.client.Load(); client != nil {
+		return client, nil
+	}
+	client, err := initClient(r.configs[name], 2, r.opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "init client %s", name)
+	}
+	entry.client.Store(client)
+	return client, nil
+}
+
+// Collection 获取名称对应Client的CollectionWrapper
+func (r *Registry) Collection(name, database, collection string, opts ...WrapperOption) (CollectionWrapper, error) {
+	client, err := r.Client(name)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewCollectionWrapper(database, collection, opts...), nil
+}
+
+// Health 检查已初始化的Client，见Client.HealthCheck，未初始化的Client不在结果中
+func (r *Registry) Health(ctx context.Context) map[string]*HealthStatus {
+	result := make(map[string]*HealthStatus)
+	for name, client := range r.initialized() {
+		result[name], _ = client.HealthCheck(ctx)
+	}
+	return result
+}
+
+// Close 关闭所有已初始化的Client，见Client.Close，之后不能再获取Client
+func (r *Registry) Close(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	var problems []string
+	for name, client := range r.initialized() {
+		if err := client.Close(ctx); err != nil && !errors.Is(err, ErrClientClosed) {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("gomongodb: close registry: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (r *Registry) initialized() map[string]*Client {
+	clients := make(map[string]*Client)
+	for name, entry := range r.entries {
+		// 不加entry.mu，避免等待其他Client的初始化
+		if client := entry.client.Load(); client != nil {
+			clients[name] = client
+		}
+	}
+	return clients
+}
+
+func sortedNames(configs map[string]Config) []string {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,83 @@
+package gomongodb
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestNewRegistry(t *testing.T) {
+	_, err := NewRegistry(map[string]Config{
+		"orders": {Hostport: "mongodb://127.0.0.1:27017", ReadConcern: "strong"},
+		"users":  {},
+	})
+	var ce *ConfigError
+	if !errors.As(err, &ce) || len(ce.Problems) != 2 {
+		t.Fatalf("NewRegistry() error = %v, want 2 problems", err)
+	}
+	if !strings.HasPrefix(ce.Problems[0], "orders: ") || !strings.HasPrefix(ce.Problems[1], "users: ") {
+		t.Errorf("problems should be prefixed by name: %v", ce.Problems)
+	}
+
+	r, err := NewRegistry(map[string]Config{
+		"users":  {Hostport: "mongodb://127.0.0.1:27017", Name: "ignored"},
+		"orders": {Hostport: "mongodb://127.0.0.1:27018"},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	if names := r.Names(); len(names) != 2 || names[0] != "orders" || names[1] != "users" {
+		t.Errorf("Names() = %v", names)
+	}
+	if r.configs["users"].Name != "users" {
+		t.Errorf("Config.Name = %s, want registry name", r.configs["users"].Name)
+	}
+	if _, err = r.Collection("unknown", "db", "col"); err == nil {
+		t.Error("Collection() should fail for unknown client")
+	}
+	if health := r.Health(context.Background()); len(health) != 0 {
+		t.Errorf("Health() = %v, clients are not initialized", health)
+	}
+
+	if err = r.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err = r.Client("orders"); !errors.Is(err, ErrRegistryClosed) {
+		t.Errorf("Client() error = %v, want ErrRegistryClosed", err)
+	}
+}
+
+func TestNewRegistryDriverOptions(t *testing.T) {
+	// hosts由WithDriverOptions提供时不要求hostport，与InitClientWithOptions一致
+	_, err := NewRegistry(map[string]Config{"users": {}},
+		WithDriverOptions(options.Client().ApplyURI("mongodb://127.0.0.1:27017")))
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+}
+
+func TestRegistryHealthDuringInit(t *testing.T) {
+	r, err := NewRegistry(map[string]Config{"users": {Hostport: "mongodb://127.0.0.1:27017"}})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	// 模拟Client正在初始化
+	entry := r.entries["users"]
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	done := make(chan map[string]*HealthStatus)
+	go func() { done <- r.Health(context.Background()) }()
+	select {
+	case health := <-done:
+		if len(health) != 0 {
+			t.Errorf("Health() = %v, client is not initialized", health)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Health() blocked by client initialization")
+	}
+}
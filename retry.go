@@ -0,0 +1,254 @@
+package gomongodb
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy 重试策略，见WithRetryPolicy、WithCollectionRetryPolicy。
+// 默认只重试幂等的操作：读操作，只使用$set、$unset、$setOnInsert的UpdateID，
+// 以及filter等值匹配唯一索引（见WithUniqueIndex）且只使用上述操作符的UpdateOne（包括upsert），其他操作需通过WithForceRetry强制重试。
+type RetryPolicy struct {
+	// MaxAttempts 最多执行的次数，包含第一次，<=1时不重试
+	MaxAttempts int
+	// InitialBackoff 第一次重试前的等待时间，默认50ms
+	InitialBackoff time.Duration
+	// MaxBackoff 等待时间的上限，默认1s
+	MaxBackoff time.Duration
+	// Multiplier 每次重试等待时间的倍数，默认2
+	Multiplier float64
+	// Jitter 等待时间的随机浮动比例，取值(0, 1]，默认0.2
+	Jitter float64
+	// Classifier 判断错误是否可重试，默认为IsRetryableError
+	Classifier func(err error) bool
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DEFAULT_RETRY_INITIAL_BACKOFF
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DEFAULT_RETRY_MAX_BACKOFF
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = 0.2
+	}
+	if p.Classifier == nil {
+		p.Classifier = IsRetryableError
+	}
+	return p
+}
+
+// backoff 第attempt次重试前的等待时间，attempt从1开始
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= p.Multiplier
+		if d >= float64(p.MaxBackoff) {
+			d = float64(p.MaxBackoff)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// 主从切换、节点关闭等场景下的错误码
+var retryableErrorCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// IsRetryableError 判断是否为网络错误、超时、主从切换等暂时性的错误
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		if se.HasErrorLabel("TransientTransactionError") || se.HasErrorLabel("RetryableWriteError") {
+			return true
+		}
+		for _, code := range retryableErrorCodes {
+			if se.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type ctxKeyForceRetry struct{}
+
+// WithForceRetry 强制重试非幂等的操作，调用方需自行保证重复执行的安全性
+func WithForceRetry(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ctxKeyForceRetry{}, true)
+}
+
+// isIdempotentOperation 重复执行不会产生额外影响的操作，uniqueIndexes为WithUniqueIndex声明的唯一索引
+func isIdempotentOperation(op *Operation, uniqueIndexes [][]string) bool {
+	switch op.Name {
+	case "Find", "FindCursor", "FindOne", "FindID", "FindProjection", "FindOneProjection", "Paginate", "FindWithTotal", "Count", "EstimatedCount", "Distinct":
+		return true
+	case "Aggregate":
+		// $out、$merge会写入数据
+		statement := sanitizeStatement(op.Pipeline)
+		return !strings.Contains(statement, `"$out"`) && !strings.Contains(statement, `"$merge"`)
+	case "UpdateID":
+		return isIdempotentUpdate(op.Update)
+	case "UpdateOne":
+		// 等值匹配唯一索引时最多命中一个文档，upsert重复执行也只会插入一次
+		return isIdempotentUpdate(op.Update) && matchesUniqueIndex(op.Filter, uniqueIndexes)
+	}
+	return false
+}
+
+// isIdempotentUpdate update只使用$set、$unset、$setOnInsert
+func isIdempotentUpdate(update interface{}) bool {
+	var keys []string
+	switch update := update.(type) {
+	case bson.M:
+		for k := range update {
+			keys = append(keys, k)
+		}
+	case builder.SafeUpdate:
+		for _, e := range update.D() {
+			keys = append(keys, e.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	for _, k := range keys {
+		if k != "$set" && k != "$unset" && k != "$setOnInsert" {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesUniqueIndex filter对_id或某个唯一索引的全部字段做等值匹配
+func matchesUniqueIndex(filter interface{}, uniqueIndexes [][]string) bool {
+	if filter == nil {
+		return false
+	}
+	b, err := bson.Marshal(filter)
+	if err != nil {
+		return false
+	}
+	var d bson.D
+	if err = bson.Unmarshal(b, &d); err != nil {
+		return false
+	}
+	eq := map[string]bool{}
+	for _, e := range d {
+		if !strings.HasPrefix(e.Key, "$") && isEqualityValue(e.Value) {
+			eq[e.Key] = true
+		}
+	}
+	for _, fields := range append([][]string{{"_id"}}, uniqueIndexes...) {
+		matched := true
+		for _, field := range fields {
+			if !eq[field] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isEqualityValue 字段的条件为等值匹配：标量、ObjectID、时间，或只有$eq的文档。
+// 正则、数组（匹配任一元素）、其他运算符均可能命中多个值
+func isEqualityValue(v interface{}) bool {
+	switch v := v.(type) {
+	case string, bool, int, int32, int64, float64, primitive.ObjectID, primitive.DateTime, time.Time, primitive.Decimal128:
+		return true
+	case bson.D:
+		return len(v) == 1 && v[0].Key == "$eq" && isEqualityValue(v[0].Value)
+	case bson.M:
+		eq, ok := v["$eq"]
+		return len(v) == 1 && ok && isEqualityValue(eq)
+	case map[string]interface{}:
+		eq, ok := v["$eq"]
+		return len(v) == 1 && ok && isEqualityValue(eq)
+	}
+	return false
+}
+
+func (c *collectionWrapper) retryPolicy() *RetryPolicy {
+	if c.wrapperOpts.retryPolicy != nil {
+		return c.wrapperOpts.retryPolicy
+	}
+	return c.client.retryPolicy
+}
+
+// retryInterceptor 按RetryPolicy重试，所有重试共用timeoutInterceptor设置的deadline，每次重试都会经过熔断并重新获取并发令牌。
+// 熔断后不再重试
+func (c *collectionWrapper) retryInterceptor(ctx context.Context, op *Operation, invoker Invoker) error {
+	policy := c.retryPolicy()
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return invoker(ctx, op)
+	}
+	force, _ := ctx.Value(ctxKeyForceRetry{}).(bool)
+	if !force && !isIdempotentOperation(op, c.wrapperOpts.uniqueIndexes) {
+		return invoker(ctx, op)
+	}
+
+	p := policy.withDefaults()
+	span := trace.SpanFromContext(ctx)
+	for attempt := 1; ; attempt++ {
+		op.Attempts = attempt
+		err := invoker(ctx, op)
+		if err == nil || attempt >= p.MaxAttempts || errors.Is(err, ErrCircuitOpen) || !p.Classifier(err) || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := p.backoff(attempt)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error()),
+			attribute.String("backoff", backoff.String()),
+		))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
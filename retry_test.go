@@ -0,0 +1,202 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func Test_IsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain", err: errors.New("plain"), want: false},
+		{name: "canceled", err: errors.Wrap(context.Canceled, "find"), want: false},
+		{name: "deadline", err: context.DeadlineExceeded, want: true},
+		{name: "not writable primary", err: mongo.CommandError{Code: 10107}, want: true},
+		{name: "transient label", err: mongo.CommandError{Code: 1, Labels: []string{"TransientTransactionError"}}, want: true},
+		{name: "duplicate key", err: mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}, want: false},
+		{name: "wrapped", err: errors.Wrap(mongo.CommandError{Code: 189}, "update"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryableError(tt.err); got != tt.want {
+				t.Errorf("IsRetryableError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isIdempotentOperation(t *testing.T) {
+	tests := []struct {
+		name string
+		op   *Operation
+		want bool
+	}{
+		{name: "find", op: &Operation{Name: "Find"}, want: true},
+		{name: "insert", op: &Operation{Name: "InsertOne"}, want: false},
+		{name: "aggregate", op: &Operation{Name: "Aggregate", Pipeline: bson.A{bson.M{"$match": bson.M{}}}}, want: true},
+		{name: "aggregate out", op: &Operation{Name: "Aggregate", Pipeline: bson.A{bson.M{"$out": "col"}}}, want: false},
+		{name: "update id set", op: &Operation{Name: "UpdateID", Update: bson.M{"$set": bson.M{"a": 1}}}, want: true},
+		{name: "update id inc", op: &Operation{Name: "UpdateID", Update: bson.M{"$set": bson.M{"a": 1}, "$inc": bson.M{"b": 1}}}, want: false},
+		{name: "update many", op: &Operation{Name: "UpdateMany", Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update id builder set", op: &Operation{Name: "UpdateID", Update: buildUpdateForTest(t, builder.NewUpdate[testDataIDSt]().Set("likes", 1))}, want: true},
+		{name: "update id builder inc", op: &Operation{Name: "UpdateID", Update: buildUpdateForTest(t, builder.NewUpdate[testDataIDSt]().Inc("likes", 1))}, want: false},
+		{name: "update one by id", op: &Operation{Name: "UpdateOne", Filter: bson.M{"_id": 1}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: true},
+		{name: "update one by unique key", op: &Operation{Name: "UpdateOne", Filter: bson.D{{Key: "uid", Value: 1}, {Key: "day", Value: bson.M{"$eq": "d"}}}, Update: bson.M{"$setOnInsert": bson.M{"a": 1}}}, want: true},
+		{name: "update one by part of unique key", op: &Operation{Name: "UpdateOne", Filter: bson.M{"uid": 1}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by range", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": bson.M{"$gt": "a"}}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by unique key inc", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": "a"}, Update: bson.M{"$inc": bson.M{"a": 1}}}, want: false},
+		{name: "update one by embedded document", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": bson.M{"a": 1}}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by regex", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": primitive.Regex{Pattern: "^a"}}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by in", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": bson.M{"$in": bson.A{"a", "b"}}}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by array", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": bson.A{"a"}}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by null", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": nil}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by eq regex", op: &Operation{Name: "UpdateOne", Filter: bson.M{"email": bson.M{"$eq": primitive.Regex{Pattern: "^a"}}}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: false},
+		{name: "update one by object id and time", op: &Operation{Name: "UpdateOne", Filter: bson.M{"uid": primitive.NewObjectID(), "day": time.Now()}, Update: bson.M{"$set": bson.M{"a": 1}}}, want: true},
+	}
+	uniqueIndexes := [][]string{{"email"}, {"uid", "day"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isIdempotentOperation(tt.op, uniqueIndexes); got != tt.want {
+				t.Errorf("isIdempotentOperation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func buildUpdateForTest(t *testing.T, update *builder.Update[testDataIDSt]) builder.SafeUpdate {
+	u, err := update.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func Test_RetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}.withDefaults()
+	p.Jitter = 0
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 4: 50 * time.Millisecond, 10: 50 * time.Millisecond} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("RetryPolicy.backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 5*time.Millisecond || got > 15*time.Millisecond {
+			t.Errorf("RetryPolicy.backoff(1) with jitter = %v", got)
+		}
+	}
+}
+
+func Test_collectionWrapper_retryInterceptor(t *testing.T) {
+	sink := &recordSink{}
+	client := newClientForTest(sink)
+	client.retryPolicy = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	calls := 0
+	failTwice := func(ctx context.Context, op *Operation) error {
+		calls++
+		if calls <= 2 {
+			return mongo.CommandError{Code: 11602}
+		}
+		return nil
+	}
+
+	c := client.NewCollectionWrapper("db", "col").(*collectionWrapper)
+	if err := c.invoke(context.Background(), c.newOperation("Find", "find"), failTwice); err != nil {
+		t.Errorf("invoke() error = %v", err)
+	}
+	if calls != 3 || sink.records[0].Retries != 2 {
+		t.Errorf("invoke() calls = %d, retries = %d, want 3, 2", calls, sink.records[0].Retries)
+	}
+
+	// 非幂等操作不重试
+	calls = 0
+	if err := c.invoke(context.Background(), c.newOperation("InsertOne", "insert"), failTwice); err == nil {
+		t.Errorf("invoke() InsertOne want error")
+	}
+	if calls != 1 {
+		t.Errorf("invoke() InsertOne calls = %d, want 1", calls)
+	}
+
+	// 强制重试
+	calls = 0
+	if err := c.invoke(WithForceRetry(context.Background()), c.newOperation("InsertOne", "insert"), failTwice); err != nil {
+		t.Errorf("invoke() InsertOne with force retry error = %v", err)
+	}
+
+	// collection级别关闭重试
+	calls = 0
+	c = client.NewCollectionWrapper("db", "col", WithCollectionRetryPolicy(RetryPolicy{})).(*collectionWrapper)
+	if err := c.invoke(context.Background(), c.newOperation("Find", "find"), failTwice); err == nil {
+		t.Errorf("invoke() with retry disabled want error")
+	}
+
+	// 所有重试共用一个deadline
+	calls = 0
+	client.retryPolicy = &RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Millisecond}
+	c = client.NewCollectionWrapper("db", "col").(*collectionWrapper)
+	ctx := WithOperationTimeout(context.Background(), 30*time.Millisecond)
+	var deadlines []time.Time
+	start := time.Now()
+	err := c.invoke(ctx, c.newOperation("Find", "find"), func(ctx context.Context, op *Operation) error {
+		calls++
+		deadline, _ := ctx.Deadline()
+		deadlines = append(deadlines, deadline)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("invoke() error = %v, calls = %d, want DeadlineExceeded after one attempt", err, calls)
+	}
+	calls, deadlines = 0, nil
+	err = c.invoke(ctx, c.newOperation("Find", "find"), func(ctx context.Context, op *Operation) error {
+		calls++
+		deadline, _ := ctx.Deadline()
+		deadlines = append(deadlines, deadline)
+		return mongo.CommandError{Code: 11602}
+	})
+	if err == nil || calls < 2 {
+		t.Fatalf("invoke() error = %v, calls = %d", err, calls)
+	}
+	for _, d := range deadlines[1:] {
+		if !d.Equal(deadlines[0]) {
+			t.Errorf("invoke() deadlines = %v, want the same deadline for all attempts", deadlines)
+		}
+	}
+
+	// 按唯一索引upsert可重试
+	calls = 0
+	c = client.NewCollectionWrapper("db", "col", WithUniqueIndex("email")).(*collectionWrapper)
+	op := c.newOperation("UpdateOne", "update")
+	op.Filter, op.Update = bson.M{"email": "a"}, bson.M{"$set": bson.M{"name": "b"}}
+	if err := c.invoke(context.Background(), op, failTwice); err != nil || calls != 3 {
+		t.Errorf("invoke() UpdateOne error = %v, calls = %d, want 3", err, calls)
+	}
+}
+
+func Test_isEqualityValue(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		want  bool
+	}{
+		{"a", true}, {int64(1), true}, {primitive.NewObjectID(), true}, {time.Now(), true},
+		{bson.D{{Key: "$eq", Value: "a"}}, true}, {bson.M{"$eq": 1}, true}, {map[string]interface{}{"$eq": 1}, true},
+		{primitive.Regex{Pattern: "^a"}, false}, {bson.M{"$in": bson.A{"a"}}, false}, {map[string]interface{}{"$gt": 1}, false},
+		{bson.D{{Key: "$eq", Value: 1}, {Key: "$ne", Value: 2}}, false}, {bson.A{"a"}, false}, {nil, false},
+	} {
+		if got := isEqualityValue(tt.value); got != tt.want {
+			t.Errorf("isEqualityValue(%#v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package gomongodb
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SlowLogConfig 慢操作日志配置，见WithSlowLog
+type SlowLogConfig struct {
+	// Threshold 超过该耗时的操作记录日志，<=0时不记录，可被WithSlowThreshold按collection覆盖
+	Threshold time.Duration
+	// Logger 默认为slog.Default()
+	Logger *slog.Logger
+	// SampleRate 采样率，取值(0, 1]，默认为1即全部记录
+	SampleRate float64
+	// MaxPerSecond 每秒最多记录的条数，<=0时不限制，被限制的条数会在下一条日志中以suppressed字段体现
+	MaxPerSecond int
+}
+
+type slowLogger struct {
+	cfg SlowLogConfig
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowCount int
+	suppressed  int
+}
+
+func newSlowLogger(cfg SlowLogConfig) *slowLogger {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+	return &slowLogger{cfg: cfg}
+}
+
+// allow 采样及限流，返回此前被限流丢弃的条数
+func (l *slowLogger) allow(now time.Time) (ok bool, suppressed int) {
+	if l.cfg.SampleRate < 1 && rand.Float64() >= l.cfg.SampleRate {
+		return false, 0
+	}
+	if l.cfg.MaxPerSecond <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.windowStart) >= time.Second {
+		l.windowStart = now
+		l.windowCount = 0
+	}
+	if l.windowCount >= l.cfg.MaxPerSecond {
+		l.suppressed++
+		return false, 0
+	}
+	l.windowCount++
+	suppressed, l.suppressed = l.suppressed, 0
+	return true, suppressed
+}
+
+// logSlow 操作耗时超过阈值时输出慢日志，collection级别的阈值优先
+func (c *collectionWrapper) logSlow(ctx context.Context, op *Operation, duration time.Duration, err error) {
+	l := c.client.slowLogger
+	if l == nil {
+		return
+	}
+	threshold := l.cfg.Threshold
+	if c.wrapperOpts.slowThreshold > 0 {
+		threshold = c.wrapperOpts.slowThreshold
+	}
+	// Stream、ForEach的耗时包含回调的耗时，不是慢查询
+	if threshold <= 0 || duration < threshold || isStreamOperation(op) {
+		return
+	}
+	ok, suppressed := l.allow(time.Now())
+	if !ok {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("target", c.client.metricTarget),
+		slog.String("db", op.Database),
+		slog.String("collection", op.Collection),
+		slog.String("method", op.Name),
+		slog.Duration("duration", duration),
+	}
+	if filter := sanitizeStatement(op.Filter); filter != "" {
+		attrs = append(attrs, slog.String("filter", filter))
+	}
+	if pipeline := sanitizeStatement(op.Pipeline); pipeline != "" {
+		attrs = append(attrs, slog.String("pipeline", pipeline))
+	}
+	if len(op.Sort) > 0 {
+		attrs = append(attrs, slog.Any("sort", op.Sort))
+	}
+	if projection := sanitizeStatement(operationProjection(op)); projection != "" {
+		attrs = append(attrs, slog.String("projection", projection))
+	}
+	if op.Skip > 0 {
+		attrs = append(attrs, slog.Int64("skip", op.Skip))
+	}
+	if op.Limit > 0 {
+		attrs = append(attrs, slog.Int64("limit", op.Limit))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	if suppressed > 0 {
+		attrs = append(attrs, slog.Int("suppressed", suppressed))
+	}
+	l.cfg.Logger.LogAttrs(ctx, slog.LevelWarn, "mongo slow operation", attrs...)
+}
+
+// operationProjection 优先取FindProjection、FindOneProjection的projection，否则从Find、FindOne的opts中取最后设置的projection
+func operationProjection(op *Operation) (projection interface{}) {
+	if op.Projection != nil {
+		return op.Projection
+	}
+	switch opts := op.Options.(type) {
+	case []*options.FindOptions:
+		for _, opt := range opts {
+			if opt != nil && opt.Projection != nil {
+				projection = opt.Projection
+			}
+		}
+	case []*options.FindOneOptions:
+		for _, opt := range opts {
+			if opt != nil && opt.Projection != nil {
+				projection = opt.Projection
+			}
+		}
+	}
+	return
+}
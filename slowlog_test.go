@@ -0,0 +1,103 @@
+package gomongodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Test_slowLogger_allow(t *testing.T) {
+	l := newSlowLogger(SlowLogConfig{MaxPerSecond: 2})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow(now); !ok {
+			t.Errorf("slowLogger.allow() #%d = false, want true", i)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow(now); ok {
+			t.Errorf("slowLogger.allow() over limit = true, want false")
+		}
+	}
+	ok, suppressed := l.allow(now.Add(time.Second))
+	if !ok || suppressed != 3 {
+		t.Errorf("slowLogger.allow() next window = %v, %d, want true, 3", ok, suppressed)
+	}
+
+	l = newSlowLogger(SlowLogConfig{SampleRate: 0.000001})
+	hit := 0
+	for i := 0; i < 1000; i++ {
+		if ok, _ := l.allow(now); ok {
+			hit++
+		}
+	}
+	if hit > 10 {
+		t.Errorf("slowLogger.allow() sampled %d of 1000, want about 0", hit)
+	}
+}
+
+func Test_collectionWrapper_logSlow(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	c := &collectionWrapper{
+		client: &Client{
+			metricTarget: "target",
+			slowLogger:   newSlowLogger(SlowLogConfig{Threshold: time.Second, Logger: logger}),
+		},
+		database:   "db",
+		collection: "col",
+	}
+	op := &Operation{
+		Database:   "db",
+		Collection: "col",
+		Name:       "Find",
+		Filter:     bson.M{"name": "secret"},
+		Sort:       []string{"-likes"},
+		Limit:      10,
+		Options:    []*options.FindOptions{options.Find().SetProjection(bson.M{"likes": 1})},
+	}
+
+	c.logSlow(context.Background(), op, time.Millisecond, nil)
+	if buf.Len() != 0 {
+		t.Fatalf("logSlow() under threshold should not log, got %s", buf.String())
+	}
+
+	c.logSlow(context.Background(), op, 2*time.Second, nil)
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("logSlow() output %s, error = %v", buf.String(), err)
+	}
+	if record["filter"] != `{"name": "?"}` || record["method"] != "Find" || record["limit"] != float64(10) ||
+		record["projection"] != `{"likes": "?"}` {
+		t.Errorf("logSlow() record = %v", record)
+	}
+
+	// collection级别的阈值覆盖client
+	buf.Reset()
+	c.wrapperOpts = newWrapperOptions([]WrapperOption{WithSlowThreshold(time.Millisecond)})
+	c.logSlow(context.Background(), op, 2*time.Millisecond, nil)
+	if buf.Len() == 0 {
+		t.Errorf("logSlow() with collection threshold should log")
+	}
+}
+
+func Test_operationProjection(t *testing.T) {
+	opts := []*options.FindOptions{options.Find().SetProjection(bson.M{"a": 1}), options.Find().SetProjection(bson.M{"b": 1})}
+	if got := operationProjection(&Operation{Options: opts}); !reflect.DeepEqual(got, bson.M{"b": 1}) {
+		t.Errorf("operationProjection() = %v, want the last projection in opts", got)
+	}
+	// FindProjection的projection优先于opts
+	if got := operationProjection(&Operation{Projection: bson.M{"c": 1}, Options: opts}); !reflect.DeepEqual(got, bson.M{"c": 1}) {
+		t.Errorf("operationProjection() = %v, want op.Projection", got)
+	}
+	if got := operationProjection(&Operation{Options: []*options.FindOneOptions{nil}}); got != nil {
+		t.Errorf("operationProjection() = %v, want nil", got)
+	}
+}
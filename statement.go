@@ -0,0 +1,182 @@
+package gomongodb
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// sanitizeStatement 将filter、pipeline等语句中的值替换为?，只保留查询结构，用于trace等场景，防止泄露业务数据。
+// 全部为标量的数组收敛为["?"]，避免$in等语句过长。
+func sanitizeStatement(statement interface{}) string {
+	if statement == nil {
+		return ""
+	}
+	t, data, err := bson.MarshalValue(statement)
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	writeSanitizedValue(&sb, bson.RawValue{Type: t, Value: data})
+	return sb.String()
+}
+
+func writeSanitizedValue(sb *strings.Builder, v bson.RawValue) {
+	switch v.Type {
+	case bsontype.EmbeddedDocument:
+		elems, err := v.Document().Elements()
+		if err != nil {
+			sb.WriteString(`"?"`)
+			return
+		}
+		sb.WriteString("{")
+		for i, elem := range elems {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(strconv.Quote(elem.Key()))
+			sb.WriteString(": ")
+			writeSanitizedValue(sb, elem.Value())
+		}
+		sb.WriteString("}")
+	case bsontype.Array:
+		values, err := v.Array().Values()
+		if err != nil {
+			sb.WriteString(`"?"`)
+			return
+		}
+		scalar := true
+		for _, value := range values {
+			if value.Type == bsontype.EmbeddedDocument || value.Type == bsontype.Array {
+				scalar = false
+				break
+			}
+		}
+		if scalar && len(values) > 0 {
+			sb.WriteString(`["?"]`)
+			return
+		}
+		sb.WriteString("[")
+		for i, value := range values {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writeSanitizedValue(sb, value)
+		}
+		sb.WriteString("]")
+	default:
+		sb.WriteString(`"?"`)
+	}
+}
+
+// statementBuilder builder包中的Filter，执行前生成语句，字段错误时不发出请求
+type statementBuilder interface {
+	Build() (bson.D, error)
+}
+
+// updateBuilder builder包中的Update，生成的语句不再进行updateSafeCheck
+type updateBuilder interface {
+	Build() (builder.SafeUpdate, error)
+}
+
+// statementFunc 延迟生成的语句，同样在statementInterceptor中生成
+type statementFunc func() (bson.D, error)
+
+func (f statementFunc) Build() (bson.D, error) {
+	return f()
+}
+
+// MarshalBSON 生成前的trace等场景
+func (f statementFunc) MarshalBSON() ([]byte, error) {
+	d, err := f()
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(d)
+}
+
+// andStatement filter与cond的$and，filter为builder时结果仍是builder，不在调用interceptor链之前生成
+func andStatement(filter interface{}, cond bson.D) interface{} {
+	b, ok := filter.(statementBuilder)
+	if !ok {
+		return andFilter(filter, cond)
+	}
+	return statementFunc(func() (bson.D, error) {
+		d, err := b.Build()
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "$and", Value: bson.A{d, cond}}}, nil
+	})
+}
+
+// statementInterceptor 在metrics、trace之后、用户的Interceptor之前生成语句，生成失败同样记录metrics、trace及慢日志
+func statementInterceptor(ctx context.Context, op *Operation, invoker Invoker) error {
+	if err := buildStatements(op); err != nil {
+		return err
+	}
+	return invoker(ctx, op)
+}
+
+// buildStatements 将op中的builder替换为生成的bson.D，interceptor看到的均为生成后的语句。
+// pipeline中各stage的值（如$match）为builder时同样生成，FindWithTotal等由filter生成的pipeline依赖于此
+func buildStatements(op *Operation) error {
+	if b, ok := op.Filter.(statementBuilder); ok {
+		filter, err := b.Build()
+		if err != nil {
+			return errors.Wrap(err, "build filter")
+		}
+		op.Filter = filter
+	}
+	if pipeline, ok := op.Pipeline.(bson.A); ok {
+		built, err := buildPipeline(pipeline)
+		if err != nil {
+			return errors.Wrap(err, "build pipeline")
+		}
+		op.Pipeline = built
+	}
+	if b, ok := op.Update.(updateBuilder); ok {
+		update, err := b.Build()
+		if err != nil {
+			return errors.Wrap(err, "build update")
+		}
+		op.Update = update
+	}
+	return nil
+}
+
+// buildPipeline 生成stage中的builder，没有builder时返回原pipeline
+func buildPipeline(pipeline bson.A) (bson.A, error) {
+	var built bson.A
+	for i, stage := range pipeline {
+		d, ok := stage.(bson.D)
+		if !ok {
+			continue
+		}
+		for j, e := range d {
+			b, ok := e.Value.(statementBuilder)
+			if !ok {
+				continue
+			}
+			value, err := b.Build()
+			if err != nil {
+				return nil, errors.Wrap(err, e.Key)
+			}
+			if built == nil {
+				built = append(bson.A{}, pipeline...)
+			}
+			stage := append(bson.D{}, built[i].(bson.D)...)
+			stage[j].Value = value
+			built[i] = stage
+		}
+	}
+	if built == nil {
+		return pipeline, nil
+	}
+	return built, nil
+}
@@ -0,0 +1,155 @@
+package gomongodb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/huaiyann/gomongodb/builder"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_sanitizeStatement(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement interface{}
+		want      string
+	}{
+		{
+			name:      "nil",
+			statement: nil,
+			want:      "",
+		},
+		{
+			name:      "bson.D",
+			statement: bson.D{{Key: "likes", Value: bson.M{"$gt": 3}}, {Key: "name", Value: "secret"}},
+			want:      `{"likes": {"$gt": "?"}, "name": "?"}`,
+		},
+		{
+			name:      "in",
+			statement: bson.M{"_id": bson.M{"$in": []int{1, 2, 3}}},
+			want:      `{"_id": {"$in": ["?"]}}`,
+		},
+		{
+			name: "or",
+			statement: bson.M{"$or": bson.A{
+				bson.M{"a": 1},
+				bson.M{"b": "x"},
+			}},
+			want: `{"$or": [{"a": "?"}, {"b": "?"}]}`,
+		},
+		{
+			name: "pipeline",
+			statement: []bson.M{
+				{"$match": bson.M{"likes": 1}},
+				{"$limit": 10},
+			},
+			want: `[{"$match": {"likes": "?"}}, {"$limit": "?"}]`,
+		},
+		{
+			name: "struct",
+			statement: struct {
+				Likes int64 `bson:"likes"`
+			}{Likes: 3},
+			want: `{"likes": "?"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeStatement(tt.statement); got != tt.want {
+				t.Errorf("sanitizeStatement() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_buildStatements(t *testing.T) {
+	sink := &recordSink{}
+	c := &collectionWrapper{client: newClientForTest(sink), database: "db", collection: "col"}
+	var seen interface{}
+	invoker := func(ctx context.Context, op *Operation) error {
+		seen = op.Filter
+		return nil
+	}
+
+	op := c.newOperation("Find", "find")
+	op.Filter = builder.NewFilter[testDataIDSt]().Gt("likes", 3)
+	if err := c.invoke(context.Background(), op, invoker); err != nil {
+		t.Fatalf("invoke() error = %v", err)
+	}
+	if !reflect.DeepEqual(seen, bson.D{{Key: "likes", Value: bson.D{{Key: "$gt", Value: 3}}}}) {
+		t.Errorf("filter = %v", seen)
+	}
+
+	// 字段错误时不执行
+	seen = nil
+	op = c.newOperation("Find", "find")
+	op.Filter = builder.NewFilter[testDataIDSt]().Gt("lieks", 3)
+	err := c.invoke(context.Background(), op, invoker)
+	if !errors.Is(err, builder.ErrUnknownField) || seen != nil {
+		t.Errorf("invoke() error = %v, filter = %v", err, seen)
+	}
+	// 生成失败同样记录metrics
+	if len(sink.records) != 2 || sink.records[1].ErrorType != ErrorTypeValidation {
+		t.Errorf("metrics records = %+v, want the second with error type validation", sink.records)
+	}
+}
+
+func Test_buildStatements_update(t *testing.T) {
+	c := &collectionWrapper{client: newClientForTest(&recordSink{}), database: "db", collection: "col"}
+	invoker := func(ctx context.Context, op *Operation) error {
+		return updateSafeCheck(op.Update)
+	}
+
+	op := c.newOperation("UpdateOne", "update")
+	op.Update = builder.NewUpdate[testDataIDSt]().Set("score", 1.5).Inc("likes", 1)
+	if err := c.invoke(context.Background(), op, invoker); err != nil {
+		t.Fatalf("invoke() error = %v", err)
+	}
+	want := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "score", Value: 1.5}}},
+		{Key: "$inc", Value: bson.D{{Key: "likes", Value: 1}}},
+	}
+	if u, ok := op.Update.(builder.SafeUpdate); !ok || !reflect.DeepEqual(u.D(), want) {
+		t.Errorf("update = %v, want %v", op.Update, want)
+	}
+
+	op = c.newOperation("UpdateOne", "update")
+	op.Update = builder.NewUpdate[testDataIDSt]().Set("likes", "many")
+	if err := c.invoke(context.Background(), op, invoker); err == nil {
+		t.Errorf("invoke() want error")
+	}
+}
+
+func Test_buildStatements_pipeline(t *testing.T) {
+	c := &collectionWrapper{client: newClientForTest(&recordSink{}), database: "db", collection: "col"}
+	var seen interface{}
+	invoker := func(ctx context.Context, op *Operation) error {
+		seen = op.Pipeline
+		return nil
+	}
+
+	group := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}}}}
+	pipeline := bson.A{bson.D{{Key: "$match", Value: builder.NewFilter[testDataIDSt]().Gt("likes", 3)}}, group}
+	op := c.newOperation("Aggregate", "aggregate")
+	op.Pipeline = pipeline
+	if err := c.invoke(context.Background(), op, invoker); err != nil {
+		t.Fatalf("invoke() error = %v", err)
+	}
+	want := bson.A{bson.D{{Key: "$match", Value: bson.D{{Key: "likes", Value: bson.D{{Key: "$gt", Value: 3}}}}}}, group}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("pipeline = %v, want %v", seen, want)
+	}
+	// 不修改调用方的pipeline
+	if _, ok := pipeline[0].(bson.D)[0].Value.(statementBuilder); !ok {
+		t.Errorf("caller pipeline modified: %v", pipeline)
+	}
+
+	seen = nil
+	op = c.newOperation("Aggregate", "aggregate")
+	op.Pipeline = bson.A{bson.D{{Key: "$match", Value: builder.NewFilter[testDataIDSt]().Gt("lieks", 3)}}}
+	if err := c.invoke(context.Background(), op, invoker); !errors.Is(err, builder.ErrUnknownField) || seen != nil {
+		t.Errorf("invoke() error = %v, pipeline = %v", err, seen)
+	}
+}
@@ -0,0 +1,159 @@
+package gomongodb
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ForEach 遍历满足filter的文档，逐个回调fn，用于读取Find会超时或内存放不下的大量数据。
+
+与FindCursor不同，整个遍历是一次wrapper操作：metrics记录遍历的总耗时及总文档数，trace在遍历期间只有一个span，fn的ctx带有该span。
+每次从服务端拉取数据（find及getMore）单独计算空闲超时，见WithStreamIdleTimeout，并只在拉取期间占用并发令牌；
+遍历的总耗时只受ctx及WithOperationTimeout限制。
+fn返回错误时停止遍历并返回该错误。无论成功与否，返回前游标均已关闭。遍历不会重试。
+*/
+func (c *collectionWrapperGeneric[T]) ForEach(ctx context.Context, filter interface{}, sort []string,
+	fn func(ctx context.Context, doc T) error, opts ...*options.FindOptions) error {
+
+	op := c.newOperation("ForEach", "find")
+	op.Filter, op.Sort, op.Options = typedFilter[T](filter), sort, opts
+	return c.invoke(ctx, op, c.streamInvoker(opts, 1, func(ctx context.Context, batch []T) error {
+		return fn(ctx, batch[0])
+	}))
+}
+
+// Stream 同ForEach，每batchSize个文档回调一次fn，最后一批可能不足batchSize。
+// opts中未设置BatchSize时，每次getMore拉取batchSize个文档。
+func (c *collectionWrapperGeneric[T]) Stream(ctx context.Context, filter interface{}, sort []string, batchSize int,
+	fn func(ctx context.Context, batch []T) error, opts ...*options.FindOptions) error {
+
+	if batchSize <= 0 {
+		return c.operationError("Stream", errors.New("gomongodb: Stream batchSize must be positive"))
+	}
+	if batchSize <= math.MaxInt32 {
+		// 放在最前面，调用方设置的BatchSize优先
+		opts = append([]*options.FindOptions{options.Find().SetBatchSize(int32(batchSize))}, opts...)
+	}
+	op := c.newOperation("Stream", "find")
+	op.Filter, op.Sort, op.Options = typedFilter[T](filter), sort, opts
+	return c.invoke(ctx, op, c.streamInvoker(opts, batchSize, fn))
+}
+
+func (c *collectionWrapperGeneric[T]) streamInvoker(opts []*options.FindOptions, batchSize int,
+	fn func(ctx context.Context, batch []T) error) Invoker {
+
+	return func(ctx context.Context, op *Operation) (err error) {
+		opt := options.Find()
+		if len(op.Sort) > 0 {
+			opt.SetSort(c.GenSortBson(op.Sort))
+		}
+		if op.Skip > 0 {
+			opt.SetSkip(op.Skip)
+		}
+		if op.Limit > 0 {
+			opt.SetLimit(op.Limit)
+		}
+		idle := c.streamIdleTimeout(ctx, op)
+
+		var cursor *mongo.Cursor
+		err = c.fetch(ctx, idle, func(ctx context.Context) (err error) {
+			cursor, err = c.Collection().Find(ctx, op.Filter, append(opts[:len(opts):len(opts)], opt)...)
+			return
+		})
+		if err != nil {
+			return
+		}
+		return c.iterate(ctx, op, cursor, idle, batchSize, fn)
+	}
+}
+
+// streamCursor Stream、ForEach用到的*mongo.Cursor的方法
+type streamCursor interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+	ID() int64
+	RemainingBatchLength() int
+}
+
+// iterate 遍历游标，每batchSize个文档回调一次fn，需要getMore时通过fetch拉取，返回前关闭游标
+func (c *collectionWrapperGeneric[T]) iterate(ctx context.Context, op *Operation, cursor streamCursor,
+	idle time.Duration, batchSize int, fn func(ctx context.Context, batch []T) error) (err error) {
+
+	defer func() {
+		// ctx可能已经取消，使用单独的ctx保证killCursors能发出
+		closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), streamCloseTimeout)
+		defer cancel()
+		if err1 := cursor.Close(closeCtx); err == nil && err1 != nil {
+			err = err1
+		}
+	}()
+
+	batch := make([]T, 0, batchSize)
+	for {
+		var more bool
+		if cursor.RemainingBatchLength() > 0 || cursor.ID() == 0 {
+			// 当前批次还有数据或游标已结束，不会发起getMore
+			more = cursor.Next(ctx)
+		} else if err = c.fetch(ctx, idle, func(ctx context.Context) error {
+			more = cursor.Next(ctx)
+			return cursor.Err()
+		}); err != nil {
+			return
+		}
+		if !more {
+			break
+		}
+
+		var doc T
+		if err = cursor.Decode(&doc); err != nil {
+			return errors.Wrap(err, "Decode")
+		}
+		op.DocumentsReturned++
+		batch = append(batch, doc)
+		if len(batch) == batchSize {
+			if err = fn(ctx, batch); err != nil {
+				return
+			}
+			// fn可能持有batch，不复用
+			batch = make([]T, 0, batchSize)
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return
+	}
+	if len(batch) > 0 {
+		err = fn(ctx, batch)
+	}
+	return
+}
+
+// streamCloseTimeout 关闭游标的超时时间
+const streamCloseTimeout = 5 * time.Second
+
+// isStreamOperation Stream、ForEach在invoker中自行获取并发令牌、计算超时
+func isStreamOperation(op *Operation) bool {
+	return op.Name == "Stream" || op.Name == "ForEach"
+}
+
+// fetch 从服务端拉取一批数据，期间占用并发令牌，idle>0时限制拉取的耗时
+func (c *collectionWrapper) fetch(ctx context.Context, idle time.Duration, f func(ctx context.Context) error) error {
+	ctx, release, err := c.client.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if idle > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, idle)
+		defer cancel()
+	}
+	return f(ctx)
+}
@@ -0,0 +1,219 @@
+package gomongodb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Test_Stream(t *testing.T) {
+	sink := &recordSink{}
+	client := newClientForTest(sink)
+	var ops []*Operation
+	client.AddInterceptor(func(ctx context.Context, op *Operation, invoker Invoker) error {
+		ops = append(ops, op)
+		return nil
+	})
+	wrapper := NewCollectionWrapper[testDataIDSt](client, "db", "col")
+	ctx := context.Background()
+
+	var oe *OperationError
+	if err := wrapper.Stream(ctx, nil, nil, 0, func(ctx context.Context, batch []testDataIDSt) error { return nil }); !errors.As(err, &oe) || oe.Collection != "col" {
+		t.Errorf("Stream() with batchSize 0 error = %v, want *OperationError", err)
+	}
+	err := wrapper.Stream(ctx, nil, []string{"likes"}, 100, func(ctx context.Context, batch []testDataIDSt) error { return nil },
+		options.Find().SetBatchSize(10))
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	err = wrapper.ForEach(ctx, nil, nil, func(ctx context.Context, doc testDataIDSt) error { return nil })
+	if err != nil {
+		t.Fatalf("ForEach() error = %v", err)
+	}
+	if len(ops) != 2 || ops[0].Name != "Stream" || ops[1].Name != "ForEach" || ops[0].Command != "find" {
+		t.Fatalf("operations = %+v", ops)
+	}
+	// 调用方的BatchSize优先
+	if opt := options.MergeFindOptions(ops[0].Options.([]*options.FindOptions)...); *opt.BatchSize != 10 {
+		t.Errorf("BatchSize = %d, want 10", *opt.BatchSize)
+	}
+	if len(sink.records) != 2 || sink.records[0].Command != "Stream" || sink.records[1].Command != "ForEach" {
+		t.Errorf("metrics records = %+v", sink.records)
+	}
+}
+
+// batchedCursor 由多个NewCursorFromDocuments组成的游标，当前批次读完后切换到下一批，模拟getMore
+type batchedCursor struct {
+	*mongo.Cursor
+	t        *testing.T
+	batches  [][]interface{}
+	getMores int
+	closed   bool
+}
+
+func newBatchedCursor(t *testing.T, batches ...[]interface{}) *batchedCursor {
+	c := &batchedCursor{t: t, batches: batches}
+	c.nextBatch()
+	return c
+}
+
+func (c *batchedCursor) nextBatch() {
+	cursor, err := mongo.NewCursorFromDocuments(c.batches[0], nil, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.Cursor, c.batches = cursor, c.batches[1:]
+}
+
+// ID 还有未拉取的批次时游标未结束
+func (c *batchedCursor) ID() int64 {
+	if len(c.batches) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (c *batchedCursor) Next(ctx context.Context) bool {
+	if c.Cursor.RemainingBatchLength() == 0 && len(c.batches) > 0 {
+		// getMore应在fetch中发出，带有空闲超时
+		if _, ok := ctx.Deadline(); !ok {
+			c.t.Error("getMore without idle timeout")
+		}
+		c.getMores++
+		c.nextBatch()
+	}
+	return c.Cursor.Next(ctx)
+}
+
+func (c *batchedCursor) Close(ctx context.Context) error {
+	c.closed = true
+	return c.Cursor.Close(ctx)
+}
+
+func Test_iterate(t *testing.T) {
+	sink := &recordSink{}
+	c := NewCollectionWrapper[testDataIDSt](newClientForTest(sink), "db", "col").(*collectionWrapperGeneric[testDataIDSt])
+	ctx := context.Background()
+	docs := func() *batchedCursor {
+		return newBatchedCursor(t,
+			[]interface{}{bson.M{"likes": 1}, bson.M{"likes": 2}, bson.M{"likes": 3}},
+			[]interface{}{bson.M{"likes": 4}},
+			[]interface{}{bson.M{"likes": 5}},
+		)
+	}
+	stream := func(cursor *batchedCursor, fn func(ctx context.Context, batch []testDataIDSt) error) error {
+		op := c.newOperation("Stream", "find")
+		return c.invoke(ctx, op, func(ctx context.Context, op *Operation) error {
+			return c.iterate(ctx, op, cursor, time.Second, 2, fn)
+		})
+	}
+
+	// 跨批次遍历，最后一批不足batchSize
+	cursor := docs()
+	var got [][]int64
+	err := stream(cursor, func(ctx context.Context, batch []testDataIDSt) error {
+		var likes []int64
+		for _, doc := range batch {
+			likes = append(likes, doc.Likes)
+		}
+		got = append(got, likes)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("iterate() error = %v", err)
+	}
+	if want := [][]int64{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+	if cursor.getMores != 2 || !cursor.closed {
+		t.Errorf("getMores = %d, closed = %v", cursor.getMores, cursor.closed)
+	}
+	if len(sink.records) != 1 || sink.records[0].Command != "Stream" || sink.records[0].DocumentsReturned != 5 || sink.records[0].Duration <= 0 {
+		t.Errorf("metrics records = %+v", sink.records)
+	}
+
+	// fn返回错误时停止遍历，不再拉取后续的批次，游标同样关闭
+	cursor, sink.records = docs(), nil
+	stop := errors.New("stop")
+	calls := 0
+	err = stream(cursor, func(ctx context.Context, batch []testDataIDSt) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || calls != 2 {
+		t.Errorf("iterate() error = %v, calls = %d", err, calls)
+	}
+	if cursor.getMores != 1 || !cursor.closed {
+		t.Errorf("getMores = %d, closed = %v", cursor.getMores, cursor.closed)
+	}
+	if len(sink.records) != 1 || sink.records[0].DocumentsReturned != 4 || !errors.Is(sink.records[0].Err, stop) {
+		t.Errorf("metrics records = %+v", sink.records)
+	}
+}
+
+func Test_fetch(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	c := &collectionWrapper{client: client, database: "db", collection: "col"}
+	ctx := context.Background()
+
+	// 遍历期间不占用令牌，回调中的操作可以获取令牌
+	op := c.newOperation("Stream", "find")
+	err := c.poolInterceptor(ctx, op, func(ctx context.Context, op *Operation) error {
+		if err := c.fetch(ctx, time.Second, func(ctx context.Context) error {
+			if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Second {
+				t.Errorf("fetch deadline = %v, %v", deadline, ok)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		_, release, err := client.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		release()
+		return nil
+	})
+	if err != nil {
+		t.Errorf("poolInterceptor() error = %v", err)
+	}
+
+	if err := c.fetch(ctx, 0, func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			t.Errorf("fetch without idle timeout has deadline")
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("fetch() error = %v", err)
+	}
+}
+
+func Test_streamIdleTimeout(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	client.timeouts = timeoutPolicy{read: 2 * time.Second}
+	c := &collectionWrapper{client: client, database: "db", collection: "col"}
+	op := c.newOperation("Stream", "find")
+
+	callerCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if d := c.streamIdleTimeout(callerCtx, op); d != 2*time.Second {
+		t.Errorf("streamIdleTimeout() = %v, want 2s", d)
+	}
+	client.timeouts.callerDeadline = true
+	if d := c.streamIdleTimeout(callerCtx, op); d != 0 {
+		t.Errorf("streamIdleTimeout() with caller deadline = %v, want 0", d)
+	}
+	c.wrapperOpts.streamIdleTimeout = time.Second
+	if d := c.streamIdleTimeout(callerCtx, op); d != time.Second {
+		t.Errorf("streamIdleTimeout() = %v, want 1s", d)
+	}
+}
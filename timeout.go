@@ -0,0 +1,98 @@
+package gomongodb
+
+import (
+	"context"
+	"time"
+)
+
+type ctxKeyOperationTimeout struct{}
+
+// WithOperationTimeout 指定ctx下wrapper操作的超时时间，优先于Config中的各项超时，可用于延长报表等重查询的超时。
+// 超时时间仍受ctx自身deadline的限制。
+func WithOperationTimeout(ctx context.Context, d time.Duration) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ctxKeyOperationTimeout{}, d)
+}
+
+// timeoutPolicy 按操作类型区分的超时时间，为0时使用Client.Timeout()
+type timeoutPolicy struct {
+	read           time.Duration
+	write          time.Duration
+	aggregate      time.Duration
+	callerDeadline bool
+}
+
+// operationTimeout 操作类型对应的默认超时时间
+func (c *collectionWrapper) operationTimeout(op *Operation) time.Duration {
+	var d time.Duration
+	switch op.Name {
+	case "Aggregate":
+		d = c.client.timeouts.aggregate
+	case "Find", "FindCursor", "FindOne", "FindID", "FindProjection", "FindOneProjection", "Paginate", "FindWithTotal", "Stream", "ForEach", "Count", "EstimatedCount", "Distinct":
+		d = c.client.timeouts.read
+	default:
+		d = c.client.timeouts.write
+	}
+	if d <= 0 {
+		d = c.client.Timeout()
+	}
+	return d
+}
+
+// timeoutInterceptor 超时时间依次取WithOperationTimeout、调用方的deadline（见WithCallerDeadline）、操作类型的超时。
+// 超时在重试之外设置，所有重试共用同一个deadline，操作的总耗时不超过超时时间。
+// UseSession只受WithOperationTimeout限制，闭包中的操作各自计算超时。
+// Stream、ForEach的整个遍历同样只受WithOperationTimeout限制，每次拉取数据的超时见streamIdleTimeout。
+func (c *collectionWrapper) timeoutInterceptor(ctx context.Context, op *Operation, invoker Invoker) error {
+	d, override := ctx.Value(ctxKeyOperationTimeout{}).(time.Duration)
+	_, hasDeadline := ctx.Deadline()
+	switch {
+	case override && d > 0:
+	case op.Name == "UseSession", isStreamOperation(op):
+		return invoker(ctx, op)
+	case c.client.timeouts.callerDeadline && hasDeadline:
+		d = 0
+	default:
+		d = c.operationTimeout(op)
+	}
+
+	if d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	return invoker(ctx, op)
+}
+
+// maxTimeInterceptor 每次执行前将剩余的时间作为maxTimeMS发送给服务端，调用方放弃后服务端也不再继续执行；
+// driver不支持写操作的maxTimeMS，写操作只在客户端取消
+func (c *collectionWrapper) maxTimeInterceptor(ctx context.Context, op *Operation, invoker Invoker) error {
+	op.maxTime = 0
+	if deadline, ok := ctx.Deadline(); ok && op.Name != "FindCursor" && !isStreamOperation(op) {
+		// FindCursor、Stream的游标在返回后继续读取，maxTimeMS会限制整个游标的执行时间
+		op.maxTime = time.Until(deadline).Truncate(time.Millisecond) + time.Millisecond
+	}
+	return invoker(ctx, op)
+}
+
+// streamIdleTimeout Stream、ForEach每次拉取数据的超时时间，依次取WithStreamIdleTimeout、调用方的deadline（见WithCallerDeadline）、读操作的超时，
+// 为0时只受ctx限制
+func (c *collectionWrapper) streamIdleTimeout(ctx context.Context, op *Operation) time.Duration {
+	if d := c.wrapperOpts.streamIdleTimeout; d > 0 {
+		return d
+	}
+	if _, ok := ctx.Deadline(); ok && c.client.timeouts.callerDeadline {
+		return 0
+	}
+	return c.operationTimeout(op)
+}
+
+// prependMaxTime 将带有maxTime的opt放在opts的最前面，调用方显式设置的MaxTime优先
+func prependMaxTime[T any](op *Operation, opts []*T, opt *T) []*T {
+	if op.maxTime <= 0 {
+		return opts[:len(opts):len(opts)]
+	}
+	return append([]*T{opt}, opts...)
+}
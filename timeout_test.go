@@ -0,0 +1,81 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Test_timeoutInterceptor(t *testing.T) {
+	client := newClientForTest(&recordSink{})
+	client.timeouts = timeoutPolicy{read: 2 * time.Second, aggregate: 30 * time.Second}
+	c := &collectionWrapper{client: client, database: "db", collection: "col"}
+
+	callerCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		op             string
+		callerDeadline bool
+		want           time.Duration // 0表示没有deadline
+		wantMaxTime    bool
+	}{
+		{"read", context.Background(), "Find", false, 2 * time.Second, true},
+		{"read projection", context.Background(), "FindProjection", false, 2 * time.Second, true},
+		{"paginate", context.Background(), "Paginate", false, 2 * time.Second, true},
+		{"find with total", context.Background(), "FindWithTotal", false, 2 * time.Second, true},
+		{"write use Timeout", context.Background(), "InsertOne", false, time.Second, true},
+		{"aggregate", context.Background(), "Aggregate", false, 30 * time.Second, true},
+		{"override", WithOperationTimeout(context.Background(), time.Hour), "Find", false, time.Hour, true},
+		{"caller deadline overridden", callerCtx, "Find", false, 2 * time.Second, true},
+		{"caller deadline as-is", callerCtx, "Find", true, time.Minute, true},
+		{"caller deadline without deadline", context.Background(), "Find", true, 2 * time.Second, true},
+		{"session", context.Background(), "UseSession", false, 0, false},
+		{"session override", WithOperationTimeout(context.Background(), time.Hour), "UseSession", false, time.Hour, true},
+		{"cursor", context.Background(), "FindCursor", false, 2 * time.Second, false},
+		{"stream", context.Background(), "Stream", false, 0, false},
+		{"stream override", WithOperationTimeout(context.Background(), time.Hour), "ForEach", false, time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.timeouts.callerDeadline = tt.callerDeadline
+			op := c.newOperation(tt.op, "")
+			_ = chainInterceptors([]Interceptor{c.timeoutInterceptor, c.maxTimeInterceptor}, func(ctx context.Context, op *Operation) error {
+				deadline, ok := ctx.Deadline()
+				if tt.want == 0 {
+					if ok {
+						t.Errorf("unexpected deadline %v", time.Until(deadline))
+					}
+				} else if got := time.Until(deadline); !ok || got > tt.want || got < tt.want-time.Second {
+					t.Errorf("deadline %v, want %v", got, tt.want)
+				}
+				if (op.maxTime > 0) != tt.wantMaxTime {
+					t.Errorf("maxTime %v, want set %v", op.maxTime, tt.wantMaxTime)
+				}
+				return nil
+			})(tt.ctx, op)
+		})
+	}
+}
+
+func Test_prependMaxTime(t *testing.T) {
+	user := options.Find().SetMaxTime(time.Minute)
+	op := &Operation{}
+	if got := prependMaxTime(op, []*options.FindOptions{user}, options.Find().SetMaxTime(op.maxTime)); len(got) != 1 {
+		t.Fatalf("len %d, want 1", len(got))
+	}
+
+	op.maxTime = time.Second
+	got := options.MergeFindOptions(prependMaxTime(op, []*options.FindOptions{user}, options.Find().SetMaxTime(op.maxTime))...)
+	if *got.MaxTime != time.Minute {
+		t.Errorf("MaxTime %v, user option should win", *got.MaxTime)
+	}
+	got = options.MergeFindOptions(prependMaxTime(op, nil, options.Find().SetMaxTime(op.maxTime))...)
+	if *got.MaxTime != time.Second {
+		t.Errorf("MaxTime %v, want 1s", *got.MaxTime)
+	}
+}
@@ -0,0 +1,53 @@
+package gomongodb
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/huaiyann/gomongodb"
+
+// traceMongo 按照OpenTelemetry数据库语义约定创建span，语句中的值会被脱敏
+func (c *collectionWrapper) traceMongo(parent context.Context, op *Operation) (ctx context.Context, span trace.Span) {
+	tp := c.client.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemMongoDB,
+		semconv.DBNamespace(op.Database),
+		semconv.DBCollectionName(op.Collection),
+		semconv.DBOperationName(op.Command),
+		attribute.String("gomongodb.client", c.client.Name()),
+	}
+	if c.client.serverAddress != "" {
+		attrs = append(attrs, semconv.ServerAddress(c.client.serverAddress))
+	}
+	if c.client.serverPort > 0 {
+		attrs = append(attrs, semconv.ServerPort(c.client.serverPort))
+	}
+	statement := op.Filter
+	if op.Pipeline != nil {
+		statement = op.Pipeline
+	}
+	if text := sanitizeStatement(statement); text != "" {
+		attrs = append(attrs, semconv.DBQueryText(text))
+	}
+	ctx, span = tp.Tracer(tracerName).Start(parent, op.Command+" "+op.Collection,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return
+}
+
+// endTrace 记录错误并结束span
+func (c *collectionWrapper) endTrace(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,111 @@
+package gomongodb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_traceInterceptor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	opts := defaultClientOptions()
+	WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))(&opts)
+
+	client := newClientForTest(&recordSink{})
+	client.tracerProvider = opts.tracerProvider
+	client.serverAddress, client.serverPort = "mongo.local", 27017
+	c := client.NewCollectionWrapper("db", "col").(*collectionWrapper)
+
+	var inner trace.SpanContext
+	op := c.newOperation("Find", "find")
+	op.Filter = bson.M{"name": "secret"}
+	err := c.invoke(context.Background(), op, func(ctx context.Context, op *Operation) error {
+		inner = trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("invoke() error = %v", err)
+	}
+
+	failure := mongo.CommandError{Code: 2, Message: "bad value"}
+	op = c.newOperation("Aggregate", "aggregate")
+	op.Pipeline = bson.A{bson.M{"$match": bson.M{"name": "secret"}}}
+	err = c.invoke(context.Background(), op, func(ctx context.Context, op *Operation) error {
+		return failure
+	})
+	var ce mongo.CommandError
+	if !errors.As(err, &ce) || ce.Code != failure.Code {
+		t.Fatalf("invoke() error = %v, want %v", err, failure)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("ended spans = %d, want 2", len(spans))
+	}
+	common := []attribute.KeyValue{
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.namespace", "db"),
+		attribute.String("db.collection.name", "col"),
+		attribute.String("gomongodb.client", "test"),
+		attribute.String("server.address", "mongo.local"),
+		attribute.Int("server.port", 27017),
+	}
+	tests := []struct {
+		span       sdktrace.ReadOnlySpan
+		name       string
+		attrs      []attribute.KeyValue
+		wantStatus codes.Code
+	}{
+		{spans[0], "find col", append([]attribute.KeyValue{
+			attribute.String("db.operation.name", "find"),
+			attribute.String("db.query.text", `{"name": "?"}`),
+		}, common...), codes.Unset},
+		{spans[1], "aggregate col", append([]attribute.KeyValue{
+			attribute.String("db.operation.name", "aggregate"),
+			attribute.String("db.query.text", `[{"$match": {"name": "?"}}]`),
+		}, common...), codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.span.Name() != tt.name || tt.span.SpanKind() != trace.SpanKindClient {
+				t.Errorf("span = %q %v, want %q client", tt.span.Name(), tt.span.SpanKind(), tt.name)
+			}
+			got := attribute.NewSet(tt.span.Attributes()...)
+			if want := attribute.NewSet(tt.attrs...); !got.Equals(&want) {
+				t.Errorf("attributes = %v, want %v", got.Encoded(attribute.DefaultEncoder()), want.Encoded(attribute.DefaultEncoder()))
+			}
+			if tt.span.Status().Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", tt.span.Status(), tt.wantStatus)
+			}
+		})
+	}
+
+	// 操作在span中执行
+	if inner.SpanID() != spans[0].SpanContext().SpanID() {
+		t.Errorf("invoker span = %v, want %v", inner.SpanID(), spans[0].SpanContext().SpanID())
+	}
+	if events := spans[0].Events(); len(events) != 0 {
+		t.Errorf("success events = %v, want none", events)
+	}
+	// 失败时记录错误
+	if status := spans[1].Status(); status.Description != failure.Error() {
+		t.Errorf("status description = %q, want %q", status.Description, failure.Error())
+	}
+	events := spans[1].Events()
+	if len(events) != 1 || events[0].Name != "exception" {
+		t.Fatalf("failure events = %v, want one exception", events)
+	}
+	if msg := attribute.NewSet(events[0].Attributes...); !msg.HasValue("exception.message") {
+		t.Errorf("exception attributes = %v", events[0].Attributes)
+	} else if v, _ := msg.Value("exception.message"); v.AsString() != failure.Error() {
+		t.Errorf("exception.message = %q, want %q", v.AsString(), failure.Error())
+	}
+}
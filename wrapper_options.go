@@ -0,0 +1,82 @@
+package gomongodb
+
+import "time"
+
+// WrapperOption 用于NewCollectionWrapper，定制单个collection的行为
+type WrapperOption func(*wrapperOptions)
+
+type wrapperOptions struct {
+	slowThreshold time.Duration
+	interceptors  []Interceptor
+	retryPolicy   *RetryPolicy
+	pageTokenKey  []byte
+	// estimatedTotal filter为空时FindWithTotal使用EstimatedCount
+	estimatedTotal bool
+	// streamIdleTimeout Stream、ForEach每次拉取数据的超时时间
+	streamIdleTimeout time.Duration
+	// uniqueIndexes 唯一索引的字段，用于判断UpdateOne能否重试
+	uniqueIndexes [][]string
+}
+
+// WithSlowThreshold 覆盖client的慢日志阈值，需client通过WithSlowLog开启慢日志
+func WithSlowThreshold(threshold time.Duration) WrapperOption {
+	return func(o *wrapperOptions) {
+		o.slowThreshold = threshold
+	}
+}
+
+// WithCollectionInterceptors 添加wrapper级别的Interceptor，在client级别的Interceptor之后执行
+func WithCollectionInterceptors(interceptors ...Interceptor) WrapperOption {
+	return func(o *wrapperOptions) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// WithCollectionRetryPolicy 覆盖client的重试策略，MaxAttempts<=1时该collection不重试
+func WithCollectionRetryPolicy(policy RetryPolicy) WrapperOption {
+	return func(o *wrapperOptions) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithPageTokenKey 设置Paginate返回的token的HMAC签名密钥，设置后不接受未签名或签名错误的token。
+// 未设置时token不签名，只做了编码，调用方可以解码看到边界文档的排序字段的值，也可以自行构造token。
+func WithPageTokenKey(key []byte) WrapperOption {
+	return func(o *wrapperOptions) {
+		o.pageTokenKey = key
+	}
+}
+
+// WithEstimatedTotal filter为空时，FindWithTotal改为Find及EstimatedDocumentCount，不再统计全表，总数为近似值
+func WithEstimatedTotal() WrapperOption {
+	return func(o *wrapperOptions) {
+		o.estimatedTotal = true
+	}
+}
+
+// WithStreamIdleTimeout 设置Stream、ForEach每次拉取数据（find及getMore）的超时时间，默认同读操作的超时。
+// 回调的耗时不计入，遍历的总耗时不受此限制。
+func WithStreamIdleTimeout(d time.Duration) WrapperOption {
+	return func(o *wrapperOptions) {
+		o.streamIdleTimeout = d
+	}
+}
+
+// WithUniqueIndex 声明collection上的唯一索引，可多次调用，复合索引传入全部字段。
+// UpdateOne的filter对某个唯一索引（或_id）的全部字段做等值匹配、update只使用$set、$unset、$setOnInsert时，
+// 重复执行最多命中同一个文档，按幂等操作重试，包括upsert。
+func WithUniqueIndex(fields ...string) WrapperOption {
+	return func(o *wrapperOptions) {
+		if len(fields) > 0 {
+			o.uniqueIndexes = append(o.uniqueIndexes, fields)
+		}
+	}
+}
+
+func newWrapperOptions(opts []WrapperOption) wrapperOptions {
+	o := wrapperOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}